@@ -2,14 +2,25 @@ package main
 
 import (
     "context"
+    "expvar"
     "net/http"
     "strings"
     "time"
 
     "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
 
+    "parallel/internal/cdn"
+    "parallel/internal/delivery"
+    "parallel/internal/janitor"
     "parallel/internal/media"
+    "parallel/internal/outbox"
+    "parallel/internal/probe"
+    "parallel/internal/profile"
     "parallel/internal/queue"
+    "parallel/internal/quota"
+    "parallel/internal/reconcile"
+    "parallel/internal/storage"
     "parallel/internal/store"
     "parallel/internal/transcode"
     "parallel/pkg/auth"
@@ -25,23 +36,111 @@ func main() {
 	if err != nil {
 		log.Fatalf("init db: %v", err)
 	}
-	redisClient := queue.NewRedis(cfg.RedisURL)
-	dispatcher := queue.NewDispatcher(redisClient, cfg.QueueStream)
+	jobs := openQueue(cfg, db)
+	if streams, ok := jobs.(*queue.Dispatcher); ok {
+		queue.NewTrimmer(streams, cfg.QueueStreamRetention, log).Start(context.Background(), cfg.QueueTrimInterval)
+	}
 
-	repo := media.NewRepository(db)
-	worker := transcode.NewFFmpeg(cfg.FFmpegBinary, cfg.TranscodeOutputDir, repo)
-	scheduler := transcode.NewScheduler(dispatcher, worker, log)
+	sources, outputs, err := openStorage(cfg)
+	if err != nil {
+		log.Fatalf("init storage: %v", err)
+	}
+
+	var purger cdn.Purger = cdn.Noop{}
+	if cfg.PurgeWebhookURL != "" {
+		purger = cdn.NewWebhook(cfg.PurgeWebhookURL, cfg.PurgeWebhookToken)
+	}
+	invalidator := cdn.NewInvalidator(purger, db, outputs, log, cfg.PurgeMaxAttempts)
+
+	quotas := quota.NewService(db, quota.Limits{
+		MaxBytes:            cfg.QuotaMaxBytes,
+		MaxTranscodeSeconds: cfg.QuotaMaxTranscodeSeconds,
+	})
+
+	repo := media.NewRepository(db, invalidator)
+	weights, err := queue.ParseWeights(cfg.QueueWeights)
+	if err != nil {
+		log.Fatalf("QUEUE_PRIORITY_WEIGHTS: %v", err)
+	}
+	plans, err := media.ParsePlanPriorities(cfg.PriorityPlans)
+	if err != nil {
+		log.Fatalf("PRIORITY_PLANS: %v", err)
+	}
+	priority := media.PriorityPolicy{
+		Plans:         plans,
+		ShortSource:   cfg.PriorityShortSource,
+		LongSource:    cfg.PriorityLongSource,
+		ElevatedRoles: strings.Split(cfg.PriorityElevatedRoles, ","),
+	}
+
+	profiles, err := profile.Load(cfg.TranscodeProfiles, cfg.TranscodeDefaultProfile)
+	if err != nil {
+		log.Fatalf("TRANSCODE_PROFILES: %v", err)
+	}
+
+	prober := probe.New(cfg.FFprobeBinary)
+	var artwork *transcode.Artwork
+	if cfg.ArtworkEnabled {
+		artwork = transcode.NewArtwork(cfg.FFmpegBinary, transcode.ArtworkOptions{
+			Interval: cfg.ThumbnailInterval,
+			Width:    cfg.ThumbnailWidth,
+			Columns:  cfg.SpriteColumns,
+			Rows:     cfg.SpriteRows,
+
+			Preview:       cfg.PreviewDuration,
+			PreviewClips:  cfg.PreviewClips,
+			PreviewFormat: cfg.PreviewFormat,
+		}, log)
+	}
+	subtitles := transcode.NewSubtitles(cfg.FFmpegBinary, cfg.WorkDir, sources, outputs, repo, quotas, profiles, log)
+	worker := transcode.NewFFmpeg(cfg.FFmpegBinary, cfg.WorkDir, sources, outputs, repo, quotas, profiles, prober, artwork, subtitles)
+	scheduler := transcode.NewScheduler(jobs, worker, log, transcode.SchedulerOptions{
+		Admission:   quotas,
+		Tracker:     repo,
+		Consumer:    cfg.QueueConsumer,
+		LeaseTTL:    cfg.JobLeaseTTL,
+		Weights:     weights,
+		Concurrency: cfg.TranscodeConcurrency,
+		MaxPerOwner: cfg.OwnerMaxConcurrency,
+		Prefetch:    cfg.QueuePrefetch,
+	})
 
 	if err := scheduler.Start(context.Background()); err != nil {
 		log.Fatalf("start scheduler: %v", err)
 	}
+	relay := outbox.NewRelay(db, scheduler, log, cfg.OutboxPollInterval, cfg.OutboxRetention)
+	relay.Start(context.Background())
+
+	reconciler := reconcile.New(repo, db, jobs, scheduler.Group(), relay, reconcile.Options{
+		Interval:      cfg.ReconcileInterval,
+		StaleAfter:    cfg.JobStaleAfter,
+		FetchTimeout:  cfg.RemoteFetchTimeout,
+		MaxRequeues:   cfg.ReconcileMaxRequeues,
+		MaxDeliveries: int64(cfg.JobMaxDeliveries),
+	}, log)
+	reconciler.Start(context.Background())
+
+	sweeper := janitor.New(repo, sources, outputs, cfg.WorkDir, quotas, janitor.Policy{
+		SourceRetention:       cfg.SourceRetention,
+		FailedOutputRetention: cfg.FailedOutputRetention,
+		OrphanGracePeriod:     cfg.OrphanGracePeriod,
+		SweepOrphanSources:    cfg.JanitorOrphanSources,
+		DryRun:                cfg.JanitorDryRun,
+	}, log)
+	sweeper.Start(context.Background(), cfg.JanitorInterval)
 
 	router := gin.New()
 	router.Use(gin.Recovery())
 
-    // Serve HLS outputs as static files without auth
+    // Serve HLS outputs from the output storage without auth, with
+    // playlist/segment specific Cache-Control so it can act as a CDN origin.
     // Example: /hls/media-123/index.m3u8
-    router.Static("/hls", cfg.TranscodeOutputDir)
+    hls := delivery.NewHandler(outputs, delivery.CachePolicy{
+        PlaylistMaxAge: cfg.PlaylistMaxAge,
+        SegmentMaxAge:  cfg.SegmentMaxAge,
+    })
+    router.GET("/hls/*key", hls.Serve)
+    router.HEAD("/hls/*key", hls.Serve)
 
     // Serve frontend (built by Vite) in production from frontend/dist
     // Allows accessing the app via the same :8080 origin.
@@ -54,6 +153,8 @@ func main() {
     router.GET("/healthz", func(c *gin.Context) {
         c.JSON(http.StatusOK, gin.H{"ok": true})
     })
+    // Runtime metrics (expvar), e.g. queue_stream_length
+    router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
     // SPA fallback for non-API, non-HLS routes.
     router.NoRoute(func(c *gin.Context) {
         p := c.Request.URL.Path
@@ -68,10 +169,14 @@ func main() {
 	apiGroup := router.Group("/api")
 	apiGroup.Use(auth.JWTMiddleware(cfg.JWTSecret))
 
-	mediaSvc := media.NewService(repo, scheduler, cfg)
+	mediaSvc := media.NewService(repo, relay, sources, outputs, quotas, prober, priority, profiles, subtitles, cfg)
+	apiGroup.GET("/v1/media", mediaSvc.HandleList)
 	apiGroup.POST("/v1/media", mediaSvc.HandleUpload)
 	apiGroup.POST("/v1/media/by-url", mediaSvc.HandleRemoteFetch)
 	apiGroup.GET("/v1/media/:id/play", mediaSvc.HandlePlaybackDescriptor)
+	apiGroup.POST("/v1/media/:id/subtitles", mediaSvc.HandleUploadSubtitle)
+	apiGroup.DELETE("/v1/media/:id", mediaSvc.HandleDelete)
+	apiGroup.GET("/v1/usage", quotas.HandleUsage)
 
 	srv := &http.Server{
 		Addr:         cfg.HTTPAddr,
@@ -85,3 +190,41 @@ func main() {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+// openQueue 按配置选择作业队列实现
+func openQueue(cfg config.Config, db *gorm.DB) queue.Queue {
+	switch cfg.QueueBackend {
+	case "memory":
+		return queue.NewMemory()
+	case "db":
+		return queue.NewDB(db, cfg.QueueDBPollInterval)
+	}
+	return queue.NewDispatcher(queue.NewRedis(cfg.RedisURL), cfg.QueueStream, cfg.QueueStreamMaxLen)
+}
+
+// openStorage 按配置构建上传源与转码产物两类存储
+func openStorage(cfg config.Config) (sources, outputs storage.Storage, err error) {
+	if cfg.StorageBackend != "s3" {
+		return storage.NewLocal(cfg.UploadDir, ""), storage.NewLocal(cfg.TranscodeOutputDir, cfg.PublicBaseURL), nil
+	}
+	s3cfg := storage.S3Config{
+		Endpoint:  cfg.S3Endpoint,
+		Region:    cfg.S3Region,
+		Bucket:    cfg.S3Bucket,
+		AccessKey: cfg.S3AccessKey,
+		SecretKey: cfg.S3SecretKey,
+		UseSSL:    cfg.S3UseSSL,
+	}
+	src, err := storage.NewS3(s3cfg, cfg.S3SourcePrefix, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := src.EnsureBucket(context.Background(), cfg.S3Region); err != nil {
+		return nil, nil, err
+	}
+	out, err := storage.NewS3(s3cfg, cfg.S3OutputPrefix, cfg.PublicBaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, out, nil
+}
@@ -0,0 +1,101 @@
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"parallel/internal/storage"
+	"parallel/internal/store"
+	"parallel/pkg/textutil"
+)
+
+// Invalidator 把存储 key 转换为公网地址并异步调用 Purger，失败按指数退避重试，
+// 每次尝试都会写入 cdn_purge_attempts
+type Invalidator struct {
+	purger      Purger
+	db          *gorm.DB
+	outputs     storage.Storage
+	logger      *log.Logger
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func NewInvalidator(purger Purger, db *gorm.DB, outputs storage.Storage, logger *log.Logger, maxAttempts int) *Invalidator {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Invalidator{
+		purger:      purger,
+		db:          db,
+		outputs:     outputs,
+		logger:      logger,
+		maxAttempts: maxAttempts,
+		baseDelay:   time.Second,
+		maxDelay:    time.Minute,
+	}
+}
+
+// Invalidate 以 "/" 结尾的 key 视为目录前缀，其余为单个对象
+func (i *Invalidator) Invalidate(ctx context.Context, mediaID uint, reason string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	req := PurgeRequest{MediaID: mediaID, Reason: reason}
+	for _, k := range keys {
+		if strings.HasSuffix(k, "/") {
+			req.Prefixes = append(req.Prefixes, i.outputs.URL(k))
+		} else {
+			req.URLs = append(req.URLs, i.outputs.URL(k))
+		}
+	}
+	// 与请求生命周期解耦，避免调用方返回后刷新被取消
+	go i.run(context.Background(), req)
+}
+
+func (i *Invalidator) run(ctx context.Context, req PurgeRequest) {
+	targets, _ := json.Marshal(struct {
+		URLs     []string `json:"urls"`
+		Prefixes []string `json:"prefixes"`
+	}{req.URLs, req.Prefixes})
+	delay := i.baseDelay
+	for attempt := 1; attempt <= i.maxAttempts; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := i.purger.Purge(callCtx, req)
+		cancel()
+		i.record(ctx, req, string(targets), attempt, err)
+		if err == nil {
+			return
+		}
+		i.logger.Printf("cdn purge media=%d attempt=%d error: %v", req.MediaID, attempt, err)
+		if attempt == i.maxAttempts {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > i.maxDelay {
+			delay = i.maxDelay
+		}
+	}
+}
+
+func (i *Invalidator) record(ctx context.Context, req PurgeRequest, targets string, attempt int, err error) {
+	row := store.CDNPurgeAttempt{
+		MediaID: req.MediaID,
+		Reason:  req.Reason,
+		Targets: targets,
+		Attempt: attempt,
+		Success: err == nil,
+	}
+	if err != nil {
+		row.Error = textutil.Truncate(err.Error(), 1024)
+	}
+	if dbErr := i.db.WithContext(ctx).Create(&row).Error; dbErr != nil {
+		i.logger.Printf("record cdn purge attempt error: %v", dbErr)
+	}
+}
@@ -0,0 +1,63 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PurgeRequest 描述一次 CDN 刷新：URLs 为精确地址，Prefixes 为目录级刷新
+type PurgeRequest struct {
+	MediaID  uint     `json:"mediaId"`
+	Reason   string   `json:"reason"`
+	URLs     []string `json:"urls"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// Purger 对接具体 CDN 厂商的刷新接口
+type Purger interface {
+	Purge(ctx context.Context, req PurgeRequest) error
+}
+
+// Noop 未配置 CDN 时的默认实现
+type Noop struct{}
+
+func (Noop) Purge(ctx context.Context, req PurgeRequest) error { return nil }
+
+// Webhook 将刷新请求以 JSON POST 到指定地址，由外部服务转换为厂商 API 调用
+type Webhook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewWebhook(url, token string) *Webhook {
+	return &Webhook{url: url, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Purge(ctx context.Context, req PurgeRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("purge webhook status=%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"parallel/internal/storage"
+)
+
+// CachePolicy 区分播放列表与分片的缓存策略：播放列表变化最频繁，只做短期缓存。
+// 分片文件名（seg_%05d 等）不随内容变化，重新转码、更换水印与重新打包字幕都会原地覆盖，
+// 因此不标记 immutable，过期后凭 ETag 重新验证，并依赖 CDN 刷新尽快失效。
+type CachePolicy struct {
+	PlaylistMaxAge time.Duration
+	SegmentMaxAge  time.Duration
+}
+
+// Handler 从输出存储读取 HLS/DASH 资源并附带 Cache-Control/ETag，可作为 CDN 回源
+type Handler struct {
+	outputs storage.Storage
+	policy  CachePolicy
+}
+
+func NewHandler(outputs storage.Storage, policy CachePolicy) *Handler {
+	return &Handler{outputs: outputs, policy: policy}
+}
+
+// Serve 处理 /hls/*key
+func (h *Handler) Serve(c *gin.Context) {
+	key := strings.TrimPrefix(path.Clean("/"+c.Param("key")), "/")
+	if key == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	ctx := c.Request.Context()
+	info, err := h.outputs.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	rc, err := h.outputs.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", storage.ContentType(key))
+	header.Set("Cache-Control", h.cacheControl(key))
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+	// ServeContent 负责 If-None-Match/If-Modified-Since 与 Range
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, info.LastModified, rs)
+		return
+	}
+	if info.ETag != "" && c.GetHeader("If-None-Match") == info.ETag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", fmt.Sprint(info.Size))
+	c.Status(http.StatusOK)
+	if c.Request.Method != http.MethodHead {
+		_, _ = io.Copy(c.Writer, rc)
+	}
+}
+
+func (h *Handler) cacheControl(key string) string {
+	if IsPlaylist(key) {
+		return fmt.Sprintf("public, max-age=%d", int(h.policy.PlaylistMaxAge.Seconds()))
+	}
+	return fmt.Sprintf("public, max-age=%d", int(h.policy.SegmentMaxAge.Seconds()))
+}
+
+// IsPlaylist 判断 key 是否为会随重新转码变化的清单文件
+func IsPlaylist(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8", ".mpd":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,420 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"parallel/internal/media"
+	"parallel/internal/quota"
+	"parallel/internal/storage"
+	"parallel/internal/store"
+)
+
+// Policy 保留策略，时长为 0 表示不启用对应规则
+type Policy struct {
+	// SourceRetention 资源就绪后源文件的保留时长
+	SourceRetention time.Duration
+	// FailedOutputRetention 转码失败后残留产物的保留时长
+	FailedOutputRetention time.Duration
+	// OrphanGracePeriod 未被数据库引用的文件至少存在这么久才视为孤儿，
+	// 用于避开上传或转码进行中、尚未落库的文件；为 0 时不清理孤儿与临时文件
+	OrphanGracePeriod time.Duration
+	// SweepOrphanSources 是否删除未被引用的上传源文件。源文件无法重新生成，
+	// 默认关闭，确认旧数据的 source_key 已补录完整后再由运维开启
+	SweepOrphanSources bool
+	// DryRun 只报告将要删除的内容，不做实际删除
+	DryRun bool
+}
+
+// Action 一条清理动作
+type Action struct {
+	Area   string // sources / outputs / work
+	Key    string
+	Size   int64
+	Reason string
+}
+
+type Report struct {
+	DryRun  bool
+	Actions []Action
+	Bytes   int64
+}
+
+func (r *Report) add(a Action) {
+	r.Actions = append(r.Actions, a)
+	r.Bytes += a.Size
+}
+
+// assetRepository 清理时用到的资源查询与更新，由 *media.Repository 实现
+type assetRepository interface {
+	ListReadyWithSourceBefore(ctx context.Context, before time.Time, limit int) ([]store.MediaAsset, error)
+	ListFailedBefore(ctx context.Context, before time.Time, afterID uint, limit int) ([]store.MediaAsset, error)
+	MarkOutputsSwept(ctx context.Context, id uint) error
+	ClearSourceKey(ctx context.Context, id uint) error
+	AdoptSourceKey(ctx context.Context, id uint, key string) (bool, error)
+	ExistingAssetIDs(ctx context.Context, ids []uint) (map[uint]bool, error)
+	ReferencedSourceKeys(ctx context.Context, keys []string) (map[string]bool, error)
+	LatestJob(ctx context.Context, mediaID uint) (*store.TranscodeJob, error)
+}
+
+// usageCounter 存储用量记账，由 *quota.Service 实现
+type usageCounter interface {
+	AddBytes(ctx context.Context, ownerID string, delta int64) error
+}
+
+// Janitor 定期按保留策略清理上传源与转码产物，并对照 media_assets 清理孤儿文件
+type Janitor struct {
+	repo    assetRepository
+	sources storage.Storage
+	outputs storage.Storage
+	workDir string
+	quota   usageCounter
+	policy  Policy
+	logger  *log.Logger
+}
+
+const batchSize = 200
+
+func New(repo *media.Repository, sources, outputs storage.Storage, workDir string, quota *quota.Service, policy Policy, logger *log.Logger) *Janitor {
+	return &Janitor{repo: repo, sources: sources, outputs: outputs, workDir: workDir, quota: quota, policy: policy, logger: logger}
+}
+
+// Start 按 interval 周期运行，interval<=0 时不启动
+func (j *Janitor) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// RunOnce 执行一轮清理并输出报告
+func (j *Janitor) RunOnce(ctx context.Context) *Report {
+	report := &Report{DryRun: j.policy.DryRun}
+	now := time.Now()
+	steps := []struct {
+		name string
+		fn   func(context.Context, time.Time, *Report) error
+	}{
+		{"legacy sources", j.adoptLegacySources},
+		{"expired sources", j.sweepExpiredSources},
+		{"failed outputs", j.sweepFailedOutputs},
+		{"orphan outputs", j.sweepOrphanOutputs},
+		{"orphan sources", j.sweepOrphanSources},
+		{"stale work files", j.sweepWorkDir},
+	}
+	for _, step := range steps {
+		if err := step.fn(ctx, now, report); err != nil {
+			j.logger.Printf("janitor %s error: %v", step.name, err)
+		}
+	}
+	verb := "deleted"
+	if report.DryRun {
+		verb = "would delete"
+	}
+	for _, a := range report.Actions {
+		j.logger.Printf("janitor %s %s/%s (%d bytes): %s", verb, a.Area, a.Key, a.Size, a.Reason)
+	}
+	j.logger.Printf("janitor %s %d objects, %d bytes", verb, len(report.Actions), report.Bytes)
+	return report
+}
+
+func (j *Janitor) sweepExpiredSources(ctx context.Context, now time.Time, report *Report) error {
+	if j.policy.SourceRetention <= 0 {
+		return nil
+	}
+	assets, err := j.repo.ListReadyWithSourceBefore(ctx, now.Add(-j.policy.SourceRetention), batchSize)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		info, err := j.sources.Stat(ctx, asset.SourceKey)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		report.add(Action{Area: "sources", Key: asset.SourceKey, Size: info.Size, Reason: "retention after READY"})
+		if j.policy.DryRun {
+			continue
+		}
+		if err := j.sources.Delete(ctx, asset.SourceKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		if err := j.repo.ClearSourceKey(ctx, asset.ID); err != nil {
+			return err
+		}
+		_ = j.quota.AddBytes(ctx, asset.OwnerID, -info.Size)
+	}
+	return nil
+}
+
+// sweepFailedOutputs 删除失败作业写入的残留产物。之前就绪过的资源只删除最近一次执行开始后
+// 写入的文件，保留旧版本产物；处理过的资源做标记，下一轮不再重复列出
+func (j *Janitor) sweepFailedOutputs(ctx context.Context, now time.Time, report *Report) error {
+	if j.policy.FailedOutputRetention <= 0 {
+		return nil
+	}
+	var afterID uint
+	for {
+		assets, err := j.repo.ListFailedBefore(ctx, now.Add(-j.policy.FailedOutputRetention), afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, asset := range assets {
+			afterID = asset.ID
+			var since time.Time
+			if len(asset.Variants) > 0 {
+				job, err := j.repo.LatestJob(ctx, asset.ID)
+				if err != nil {
+					return err
+				}
+				if job == nil {
+					continue
+				}
+				since = job.CreatedAt
+			}
+			if err := j.deleteOutputs(ctx, asset.OwnerID, media.OutputPrefix(asset.ID)+"/", since, "partial output of FAILED job", report); err != nil {
+				return err
+			}
+			if j.policy.DryRun {
+				continue
+			}
+			if err := j.repo.MarkOutputsSwept(ctx, asset.ID); err != nil {
+				return err
+			}
+		}
+		if len(assets) < batchSize {
+			return nil
+		}
+	}
+}
+
+// sweepOrphanOutputs 删除 media-<id>/ 下对应资源已不存在的产物
+func (j *Janitor) sweepOrphanOutputs(ctx context.Context, now time.Time, report *Report) error {
+	if j.policy.OrphanGracePeriod <= 0 {
+		return nil
+	}
+	objects, err := j.outputs.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	newest := make(map[uint]time.Time)
+	for _, obj := range objects {
+		id, ok := outputMediaID(obj.Key)
+		if !ok {
+			continue
+		}
+		if obj.LastModified.After(newest[id]) {
+			newest[id] = obj.LastModified
+		}
+	}
+	ids := make([]uint, 0, len(newest))
+	for id, t := range newest {
+		if now.Sub(t) >= j.policy.OrphanGracePeriod {
+			ids = append(ids, id)
+		}
+	}
+	existing, err := j.repo.ExistingAssetIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if existing[id] {
+			continue
+		}
+		// 资源删除时已扣减用量，孤儿文件不再计入任何 owner
+		if err := j.deleteOutputs(ctx, "", media.OutputPrefix(id)+"/", time.Time{}, "orphan: media asset not found", report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptLegacySources 旧版本远程拉取的源文件（remote-<id>-<ns>.mp4）没有记录 source_key，
+// 按文件名中的资源 ID 补录，避免被当作孤儿删除，删除资源时也能一并清理
+func (j *Janitor) adoptLegacySources(ctx context.Context, now time.Time, report *Report) error {
+	objects, err := j.sources.List(ctx, "remote-")
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		referenced, err := j.repo.ReferencedSourceKeys(ctx, keys[start:end])
+		if err != nil {
+			return err
+		}
+		for _, key := range keys[start:end] {
+			id, ok := remoteMediaID(key)
+			if !ok || referenced[key] {
+				continue
+			}
+			adopted, err := j.repo.AdoptSourceKey(ctx, id, key)
+			if err != nil {
+				return err
+			}
+			if adopted {
+				j.logger.Printf("janitor adopted legacy source %s for media %d", key, id)
+			}
+		}
+	}
+	return nil
+}
+
+// sweepOrphanSources 删除未被任何资源引用的源文件，需显式开启
+func (j *Janitor) sweepOrphanSources(ctx context.Context, now time.Time, report *Report) error {
+	if !j.policy.SweepOrphanSources || j.policy.OrphanGracePeriod <= 0 {
+		return nil
+	}
+	objects, err := j.sources.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	candidates := make([]storage.ObjectInfo, 0)
+	keys := make([]string, 0)
+	for _, obj := range objects {
+		if now.Sub(obj.LastModified) < j.policy.OrphanGracePeriod {
+			continue
+		}
+		candidates = append(candidates, obj)
+		keys = append(keys, obj.Key)
+	}
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		referenced, err := j.repo.ReferencedSourceKeys(ctx, keys[start:end])
+		if err != nil {
+			return err
+		}
+		for _, obj := range candidates[start:end] {
+			if referenced[obj.Key] {
+				continue
+			}
+			report.add(Action{Area: "sources", Key: obj.Key, Size: obj.Size, Reason: "orphan: not referenced by any media asset"})
+			if j.policy.DryRun {
+				continue
+			}
+			if err := j.sources.Delete(ctx, obj.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sweepWorkDir 清理进程崩溃后遗留在工作目录的临时源文件与半成品输出
+func (j *Janitor) sweepWorkDir(ctx context.Context, now time.Time, report *Report) error {
+	if j.workDir == "" || j.policy.OrphanGracePeriod <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(j.workDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < j.policy.OrphanGracePeriod {
+			continue
+		}
+		p := filepath.Join(j.workDir, entry.Name())
+		report.add(Action{Area: "work", Key: entry.Name(), Size: dirSize(p), Reason: "stale temporary file"})
+		if j.policy.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOutputs 删除 prefix 下的产物；since 非零时只删除此后写入的文件
+func (j *Janitor) deleteOutputs(ctx context.Context, ownerID, prefix string, since time.Time, reason string, report *Report) error {
+	objects, err := j.outputs.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	var freed int64
+	defer func() { _ = j.quota.AddBytes(ctx, ownerID, -freed) }()
+	for _, obj := range objects {
+		if obj.LastModified.Before(since) {
+			continue
+		}
+		report.add(Action{Area: "outputs", Key: obj.Key, Size: obj.Size, Reason: reason})
+		if j.policy.DryRun {
+			continue
+		}
+		if err := j.outputs.Delete(ctx, obj.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		freed += obj.Size
+	}
+	return nil
+}
+
+// outputMediaID 从 media-<id>/... 形式的 key 中解析资源 ID
+func outputMediaID(key string) (uint, bool) {
+	dir, _, found := strings.Cut(key, "/")
+	if !found || !strings.HasPrefix(dir, "media-") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(dir, "media-"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// remoteMediaID 从 remote-<id>-<ns>.mp4 形式的 key 中解析资源 ID
+func remoteMediaID(key string) (uint, bool) {
+	rest, ok := strings.CutPrefix(key, "remote-")
+	if !ok {
+		return 0, false
+	}
+	idPart, _, found := strings.Cut(rest, "-")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func dirSize(p string) int64 {
+	var total int64
+	_ = filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
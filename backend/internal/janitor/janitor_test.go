@@ -0,0 +1,316 @@
+package janitor
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"parallel/internal/media"
+	"parallel/internal/storage"
+	"parallel/internal/store"
+)
+
+// fakeRepo 以内存 map 模拟 media_assets 与 transcode_jobs
+type fakeRepo struct {
+	assets map[uint]*store.MediaAsset
+	jobs   map[uint]*store.TranscodeJob
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{assets: map[uint]*store.MediaAsset{}, jobs: map[uint]*store.TranscodeJob{}}
+}
+
+func (f *fakeRepo) sortedIDs() []uint {
+	ids := make([]uint, 0, len(f.assets))
+	for id := range f.assets {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (f *fakeRepo) ListReadyWithSourceBefore(ctx context.Context, before time.Time, limit int) ([]store.MediaAsset, error) {
+	var out []store.MediaAsset
+	for _, id := range f.sortedIDs() {
+		a := f.assets[id]
+		if a.Status == media.StatusReady && a.UpdatedAt.Before(before) && a.SourceKey != "" && len(out) < limit {
+			out = append(out, *a)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) ListFailedBefore(ctx context.Context, before time.Time, afterID uint, limit int) ([]store.MediaAsset, error) {
+	var out []store.MediaAsset
+	for _, id := range f.sortedIDs() {
+		a := f.assets[id]
+		if id > afterID && a.Status == media.StatusFailed && a.UpdatedAt.Before(before) && a.OutputsSweptAt == nil && len(out) < limit {
+			out = append(out, *a)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) MarkOutputsSwept(ctx context.Context, id uint) error {
+	now := time.Now()
+	f.assets[id].OutputsSweptAt = &now
+	return nil
+}
+
+func (f *fakeRepo) ClearSourceKey(ctx context.Context, id uint) error {
+	f.assets[id].SourceKey = ""
+	return nil
+}
+
+func (f *fakeRepo) AdoptSourceKey(ctx context.Context, id uint, key string) (bool, error) {
+	a, ok := f.assets[id]
+	if !ok || a.SourceKey != "" {
+		return false, nil
+	}
+	a.SourceKey = key
+	return true, nil
+}
+
+func (f *fakeRepo) ExistingAssetIDs(ctx context.Context, ids []uint) (map[uint]bool, error) {
+	out := map[uint]bool{}
+	for _, id := range ids {
+		if _, ok := f.assets[id]; ok {
+			out[id] = true
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) ReferencedSourceKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	out := map[string]bool{}
+	for _, a := range f.assets {
+		for _, k := range keys {
+			if a.SourceKey == k {
+				out[k] = true
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) LatestJob(ctx context.Context, mediaID uint) (*store.TranscodeJob, error) {
+	return f.jobs[mediaID], nil
+}
+
+// fakeUsage 记录每个 owner 的用量变化
+type fakeUsage map[string]int64
+
+func (u fakeUsage) AddBytes(ctx context.Context, ownerID string, delta int64) error {
+	u[ownerID] += delta
+	return nil
+}
+
+type fixture struct {
+	repo    *fakeRepo
+	usage   fakeUsage
+	sources *storage.Local
+	outputs *storage.Local
+	workDir string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	root := t.TempDir()
+	f := &fixture{
+		repo:    newFakeRepo(),
+		usage:   fakeUsage{},
+		sources: storage.NewLocal(filepath.Join(root, "sources"), ""),
+		outputs: storage.NewLocal(filepath.Join(root, "outputs"), ""),
+		workDir: filepath.Join(root, "work"),
+	}
+	if err := os.MkdirAll(f.workDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *fixture) janitor(policy Policy) *Janitor {
+	return &Janitor{repo: f.repo, sources: f.sources, outputs: f.outputs, workDir: f.workDir, quota: f.usage, policy: policy, logger: log.New(io.Discard, "", 0)}
+}
+
+// put 写入对象并把修改时间设为 mtime
+func put(t *testing.T, st *storage.Local, key, body string, mtime time.Time) {
+	t.Helper()
+	if err := st.Put(context.Background(), key, strings.NewReader(body), int64(len(body)), ""); err != nil {
+		t.Fatal(err)
+	}
+	p, err := st.Path(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(t *testing.T, st *storage.Local, key string) bool {
+	t.Helper()
+	_, err := st.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestZeroGracePeriodDisablesOrphanSweeps(t *testing.T) {
+	f := newFixture(t)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	put(t, f.outputs, "media-9/720p/index.m3u8", "orphan", old)
+	put(t, f.sources, "upload-1-a.mp4", "orphan", old)
+	stale := filepath.Join(f.workDir, "job-1")
+	if err := os.WriteFile(stale, []byte("tmp"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	report := f.janitor(Policy{OrphanGracePeriod: 0, SweepOrphanSources: true}).RunOnce(context.Background())
+	if len(report.Actions) != 0 {
+		t.Fatalf("宽限期为 0 时不应清理任何文件: %+v", report.Actions)
+	}
+	if !exists(t, f.outputs, "media-9/720p/index.m3u8") || !exists(t, f.sources, "upload-1-a.mp4") {
+		t.Fatal("宽限期为 0 时文件被删除")
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("宽限期为 0 时工作目录被清理: %v", err)
+	}
+
+	report = f.janitor(Policy{OrphanGracePeriod: time.Hour, SweepOrphanSources: true}).RunOnce(context.Background())
+	if len(report.Actions) != 3 {
+		t.Fatalf("开启宽限期后应清理 3 个孤儿, got %+v", report.Actions)
+	}
+}
+
+func TestOrphanSweepKeepsLegacySources(t *testing.T) {
+	f := newFixture(t)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	// 旧版本远程拉取的资源没有记录 source_key，按文件名补录
+	f.repo.assets[7] = &store.MediaAsset{ID: 7, OwnerID: "u1", Status: media.StatusReady, UpdatedAt: old}
+	// 0016 迁移规范化后的上传 key
+	f.repo.assets[8] = &store.MediaAsset{ID: 8, OwnerID: "u1", Status: media.StatusReady, SourceKey: "upload-1-b.mp4", UpdatedAt: old}
+	put(t, f.sources, "remote-7-1700000000.mp4", "legacy", old)
+	put(t, f.sources, "upload-1-b.mp4", "upload", old)
+	put(t, f.sources, "remote-99-1700000000.mp4", "deleted asset", old)
+	put(t, f.sources, "upload-2-c.mp4", "orphan", old)
+
+	// 默认不删除孤儿源文件，只补录
+	report := f.janitor(Policy{OrphanGracePeriod: time.Hour}).RunOnce(context.Background())
+	for _, a := range report.Actions {
+		if a.Area == "sources" {
+			t.Fatalf("未开启 SweepOrphanSources 时删除了源文件: %+v", a)
+		}
+	}
+	if got := f.repo.assets[7].SourceKey; got != "remote-7-1700000000.mp4" {
+		t.Fatalf("旧源文件未补录, source_key = %q", got)
+	}
+
+	report = f.janitor(Policy{OrphanGracePeriod: time.Hour, SweepOrphanSources: true}).RunOnce(context.Background())
+	var deleted []string
+	for _, a := range report.Actions {
+		if a.Area == "sources" {
+			deleted = append(deleted, a.Key)
+		}
+	}
+	sort.Strings(deleted)
+	want := []string{"remote-99-1700000000.mp4", "upload-2-c.mp4"}
+	if strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Fatalf("删除的源文件 = %v, want %v", deleted, want)
+	}
+	for _, key := range []string{"remote-7-1700000000.mp4", "upload-1-b.mp4"} {
+		if !exists(t, f.sources, key) {
+			t.Errorf("被引用的源文件 %s 被删除", key)
+		}
+	}
+}
+
+func TestSweepFailedOutputsPagesThroughAllAssets(t *testing.T) {
+	f := newFixture(t)
+	old := time.Now().Add(-48 * time.Hour)
+	total := 2*batchSize + 3
+	for id := uint(1); id <= uint(total); id++ {
+		f.repo.assets[id] = &store.MediaAsset{ID: id, OwnerID: "u1", Status: media.StatusFailed, UpdatedAt: old}
+	}
+	put(t, f.outputs, "media-1/720p/seg_00000.ts", "aa", old)
+	put(t, f.outputs, media.OutputPrefix(uint(total))+"/720p/seg_00000.ts", "bbb", old)
+
+	policy := Policy{FailedOutputRetention: time.Hour, DryRun: true}
+	report := &Report{DryRun: true}
+	if err := f.janitor(policy).sweepFailedOutputs(context.Background(), time.Now(), report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 2 || report.Bytes != 5 {
+		t.Fatalf("DryRun 应列出两页之后的产物: %+v", report.Actions)
+	}
+
+	policy.DryRun = false
+	report = &Report{}
+	if err := f.janitor(policy).sweepFailedOutputs(context.Background(), time.Now(), report); err != nil {
+		t.Fatal(err)
+	}
+	for id, a := range f.repo.assets {
+		if a.OutputsSweptAt == nil {
+			t.Fatalf("资源 %d 未被处理", id)
+		}
+	}
+	if exists(t, f.outputs, "media-1/720p/seg_00000.ts") || exists(t, f.outputs, media.OutputPrefix(uint(total))+"/720p/seg_00000.ts") {
+		t.Fatal("失败产物未删除")
+	}
+	if f.usage["u1"] != -5 {
+		t.Fatalf("用量变化 = %d, want -5", f.usage["u1"])
+	}
+
+	// 已标记的资源下一轮不再列出
+	report = &Report{}
+	if err := f.janitor(policy).sweepFailedOutputs(context.Background(), time.Now(), report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 {
+		t.Fatalf("重复清理: %+v", report.Actions)
+	}
+}
+
+func TestDeleteOutputsKeepsOutputsBeforeSince(t *testing.T) {
+	f := newFixture(t)
+	since := time.Now().Add(-2 * time.Hour)
+	put(t, f.outputs, "media-3/720p/index.m3u8", "old-playlist", since.Add(-time.Hour))
+	put(t, f.outputs, "media-3/720p/seg_00000.ts", "old", since.Add(-time.Minute))
+	put(t, f.outputs, "media-3/1080p/seg_00000.ts", "partial", since.Add(time.Minute))
+
+	report := &Report{}
+	if err := f.janitor(Policy{}).deleteOutputs(context.Background(), "u1", "media-3/", since, "test", report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 1 || report.Actions[0].Key != "media-3/1080p/seg_00000.ts" {
+		t.Fatalf("只应删除 since 之后写入的产物: %+v", report.Actions)
+	}
+	if !exists(t, f.outputs, "media-3/720p/index.m3u8") || !exists(t, f.outputs, "media-3/720p/seg_00000.ts") {
+		t.Fatal("since 之前的产物被删除")
+	}
+	if exists(t, f.outputs, "media-3/1080p/seg_00000.ts") {
+		t.Fatal("since 之后的产物未删除")
+	}
+	if f.usage["u1"] != -int64(len("partial")) {
+		t.Fatalf("用量变化 = %d", f.usage["u1"])
+	}
+
+	// 之前就绪过的资源再次失败：只删除最近一次作业开始后写入的文件
+	f.repo.assets[3] = &store.MediaAsset{ID: 3, OwnerID: "u1", Status: media.StatusFailed, UpdatedAt: since,
+		Variants: []store.MediaVariant{{MediaID: 3, Quality: "720p"}}}
+	f.repo.jobs[3] = &store.TranscodeJob{MediaID: 3, CreatedAt: since.Add(-30 * time.Second)}
+	report = &Report{}
+	if err := f.janitor(Policy{FailedOutputRetention: time.Minute}).sweepFailedOutputs(context.Background(), time.Now(), report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Actions) != 0 || !exists(t, f.outputs, "media-3/720p/seg_00000.ts") {
+		t.Fatalf("旧版本产物不应被删除: %+v", report.Actions)
+	}
+}
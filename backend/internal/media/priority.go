@@ -0,0 +1,90 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"parallel/internal/queue"
+)
+
+var (
+	ErrInvalidPriority   = errors.New("优先级非法")
+	ErrPriorityForbidden = errors.New("当前角色无权指定该优先级")
+)
+
+// PriorityPolicy 决定作业进入哪条优先级队列：
+// 默认取套餐对应的优先级，短视频提升一级、长视频降低一级；
+// 请求中显式指定的优先级不得高于套餐默认值，ElevatedRoles 中的角色不受限
+type PriorityPolicy struct {
+	Plans         map[string]queue.Priority
+	ShortSource   time.Duration
+	LongSource    time.Duration
+	ElevatedRoles []string
+}
+
+// Check 校验请求中显式指定的优先级，未指定时返回空串
+func (p PriorityPolicy) Check(plan, role, requested string) (queue.Priority, error) {
+	if strings.TrimSpace(requested) == "" {
+		return "", nil
+	}
+	prio, err := queue.ParsePriority(requested)
+	if err != nil {
+		return "", ErrInvalidPriority
+	}
+	if prio.Rank() < p.planPriority(plan).Rank() && !p.elevated(role) {
+		return "", ErrPriorityForbidden
+	}
+	return prio, nil
+}
+
+// Auto 按套餐与源文件时长（秒，未知时为 0）推断优先级
+func (p PriorityPolicy) Auto(plan string, duration float64) queue.Priority {
+	prio := p.planPriority(plan)
+	d := time.Duration(duration * float64(time.Second))
+	switch {
+	case d <= 0:
+	case p.ShortSource > 0 && d <= p.ShortSource:
+		prio = prio.Shift(-1)
+	case p.LongSource > 0 && d >= p.LongSource:
+		prio = prio.Shift(1)
+	}
+	return prio
+}
+
+func (p PriorityPolicy) planPriority(plan string) queue.Priority {
+	if prio, ok := p.Plans[plan]; ok {
+		return prio
+	}
+	return queue.PriorityNormal
+}
+
+func (p PriorityPolicy) elevated(role string) bool {
+	for _, r := range p.ElevatedRoles {
+		if role != "" && r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePlanPriorities 解析 "pro=high,free=bulk" 形式的套餐优先级映射
+func ParsePlanPriorities(s string) (map[string]queue.Priority, error) {
+	plans := map[string]queue.Priority{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		plan, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("套餐优先级格式错误: %s", part)
+		}
+		prio, err := queue.ParsePriority(value)
+		if err != nil {
+			return nil, err
+		}
+		plans[strings.TrimSpace(plan)] = prio
+	}
+	return plans, nil
+}
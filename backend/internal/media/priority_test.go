@@ -0,0 +1,80 @@
+package media
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"parallel/internal/queue"
+)
+
+func testPolicy() PriorityPolicy {
+	return PriorityPolicy{
+		Plans:         map[string]queue.Priority{"pro": queue.PriorityHigh, "free": queue.PriorityBulk},
+		ShortSource:   time.Minute,
+		LongSource:    time.Hour,
+		ElevatedRoles: []string{"admin"},
+	}
+}
+
+func TestPriorityPolicyAuto(t *testing.T) {
+	tests := []struct {
+		plan     string
+		duration float64
+		want     queue.Priority
+	}{
+		{"", 0, queue.PriorityNormal},
+		{"unknown", 600, queue.PriorityNormal},
+		{"", 30, queue.PriorityHigh},
+		{"", 2 * 3600, queue.PriorityBulk},
+		{"pro", 30, queue.PriorityHigh},
+		{"pro", 2 * 3600, queue.PriorityNormal},
+		{"free", 30, queue.PriorityNormal},
+		{"free", 2 * 3600, queue.PriorityBulk},
+	}
+	p := testPolicy()
+	for _, tt := range tests {
+		if got := p.Auto(tt.plan, tt.duration); got != tt.want {
+			t.Errorf("Auto(%q, %v) = %s, want %s", tt.plan, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityPolicyCheck(t *testing.T) {
+	tests := []struct {
+		plan, role, requested string
+		want                  queue.Priority
+		wantErr               error
+	}{
+		{"free", "", "", "", nil},
+		{"free", "", "bulk", queue.PriorityBulk, nil},
+		{"free", "", "high", "", ErrPriorityForbidden},
+		{"free", "admin", "high", queue.PriorityHigh, nil},
+		{"pro", "", "high", queue.PriorityHigh, nil},
+		{"", "", "normal", queue.PriorityNormal, nil},
+		{"", "", "urgent", "", ErrInvalidPriority},
+	}
+	p := testPolicy()
+	for _, tt := range tests {
+		got, err := p.Check(tt.plan, tt.role, tt.requested)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("Check(%q, %q, %q) = %q, %v; want %q, %v", tt.plan, tt.role, tt.requested, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePlanPriorities(t *testing.T) {
+	got, err := ParsePlanPriorities("pro=high, free=bulk,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["pro"] != queue.PriorityHigh || got["free"] != queue.PriorityBulk || len(got) != 2 {
+		t.Fatalf("ParsePlanPriorities = %v", got)
+	}
+	if _, err := ParsePlanPriorities("pro"); err == nil {
+		t.Error("缺少 = 时应返回错误")
+	}
+	if _, err := ParsePlanPriorities("pro=urgent"); err == nil {
+		t.Error("未知优先级应返回错误")
+	}
+}
@@ -2,10 +2,17 @@ package media
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"parallel/internal/queue"
 	"parallel/internal/store"
+	"parallel/pkg/textutil"
 )
 
 const (
@@ -14,8 +21,37 @@ const (
 	StatusFailed     = "FAILED"
 )
 
+// variant 的 Format：播放清单，以及转码附带生成的封面与缩略图索引
+const (
+	FormatHLS        = "HLS"
+	FormatDASH       = "DASH"
+	FormatPoster     = "POSTER"
+	FormatThumbnails = "THUMBNAILS"
+	FormatTrickplay  = "TRICKPLAY"
+	FormatPreview    = "PREVIEW"
+	FormatAudio      = "AUDIO" // 备选音轨的媒体播放列表，quality 为 audio-<序号>，序号 0 为默认音轨
+)
+
+// 触发 CDN 刷新的原因
+const (
+	PurgeReasonRetranscode = "retranscode"
+	PurgeReasonDelete      = "delete"
+	PurgeReasonSubtitles   = "subtitles"
+)
+
+// PurgeHook 在 variant 被替换或资源删除后通知 CDN 刷新。
+// keys 为输出存储中的 key，以 "/" 结尾表示目录前缀。
+type PurgeHook interface {
+	Invalidate(ctx context.Context, mediaID uint, reason string, keys []string)
+}
+
+type noopPurgeHook struct{}
+
+func (noopPurgeHook) Invalidate(context.Context, uint, string, []string) {}
+
 type Repository struct {
-	db *gorm.DB
+	db    *gorm.DB
+	purge PurgeHook
 }
 
 type Asset struct {
@@ -23,33 +59,151 @@ type Asset struct {
 }
 
 type Variant struct {
-	Quality string `json:"quality"`
-	Format  string `json:"format"`
-	CDNURL  string `json:"cdnUrl"`
+	Quality    string `json:"quality"`
+	Format     string `json:"format"`
+	Codec      string `json:"codec,omitempty"` // RFC 6381 CODECS，主播放列表与清单为空
+	CDNURL     string `json:"cdnUrl"`
+	StorageKey string `json:"-"`
+	Profile    string `json:"profile,omitempty"`
+	Language   string `json:"-"`
+	Label      string `json:"-"`
 }
 
-func NewRepository(db *gorm.DB) *Repository {
-	return &Repository{db: db}
+func NewRepository(db *gorm.DB, purge PurgeHook) *Repository {
+	if purge == nil {
+		purge = noopPurgeHook{}
+	}
+	return &Repository{db: db, purge: purge}
 }
 
-func (r *Repository) CreateAsset(ctx context.Context, ownerID, originalURL string) (uint, error) {
-	asset := &store.MediaAsset{OwnerID: ownerID, Status: StatusProcessing, OriginalURL: originalURL}
+// OutputPrefix 返回资源转码产物在输出存储中的目录
+func OutputPrefix(id uint) string {
+	return fmt.Sprintf("media-%d", id)
+}
+
+func (r *Repository) CreateAsset(ctx context.Context, ownerID, originalURL, sourceKey string) (uint, error) {
+	asset := &store.MediaAsset{OwnerID: ownerID, Status: StatusProcessing, OriginalURL: originalURL, SourceKey: sourceKey}
 	if err := r.db.WithContext(ctx).Create(asset).Error; err != nil {
 		return 0, err
 	}
 	return asset.ID, nil
 }
 
+// CreateAssetWithJob 在同一事务中写入资源记录与作业 outbox，保证二者不会只成功一半
+func (r *Repository) CreateAssetWithJob(ctx context.Context, ownerID, originalURL, sourceKey string, duration float64, job queue.JobPayload) (uint, error) {
+	asset := &store.MediaAsset{
+		OwnerID:     ownerID,
+		Status:      StatusProcessing,
+		OriginalURL: originalURL,
+		SourceKey:   sourceKey,
+		Duration:    duration,
+		Priority:    string(job.Priority),
+		Profile:     job.Profile,
+		Watermark:   job.Watermark,
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(asset).Error; err != nil {
+			return err
+		}
+		job.MediaID = asset.ID
+		return insertOutbox(tx, job)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return asset.ID, nil
+}
+
+// SetSourceAndEnqueue 远程源文件下载完成后，同事务记录 source_key 并写入作业 outbox
+func (r *Repository) SetSourceAndEnqueue(ctx context.Context, id uint, sourceKey string, duration float64, job queue.JobPayload) error {
+	job.MediaID = id
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&store.MediaAsset{}).Where("id = ?", id).Updates(map[string]any{
+			"source_key": sourceKey,
+			"duration":   duration,
+			"priority":   string(job.Priority),
+			"profile":    job.Profile,
+			"watermark":  job.Watermark,
+		}).Error; err != nil {
+			return err
+		}
+		return insertOutbox(tx, job)
+	})
+}
+
+func insertOutbox(tx *gorm.DB, job queue.JobPayload) error {
+	if job.IdempotencyKey == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return err
+		}
+		job.IdempotencyKey = key
+	}
+	raw, err := queue.Encode(job)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&store.JobOutbox{MediaID: job.MediaID, Payload: string(raw), Status: store.OutboxPending}).Error
+}
+
+// MarkFailed 标记资源失败并记录原因，供前端展示与排查
+func (r *Repository) MarkFailed(ctx context.Context, id uint, reason string) error {
+	return r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id = ?", id).Updates(map[string]any{
+		"status":           StatusFailed,
+		"fail_reason":      textutil.Truncate(reason, 512),
+		"outputs_swept_at": nil,
+	}).Error
+}
+
 func (r *Repository) UpdateStatus(ctx context.Context, id uint, status string) error {
 	return r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id = ?", id).Update("status", status).Error
 }
 
+// SaveVariants 以新结果整体替换资源的 variant：按 (media_id, quality, format, codec) upsert，
+// 再删除新结果中不存在的旧记录，重复执行结果不变。替换掉旧结果时触发 CDN 刷新。
 func (r *Repository) SaveVariants(ctx context.Context, id uint, variants []Variant) error {
 	dbVariants := make([]store.MediaVariant, 0, len(variants))
+	keep := make(map[string]bool, len(variants))
 	for _, v := range variants {
-		dbVariants = append(dbVariants, store.MediaVariant{MediaID: id, Quality: v.Quality, Format: v.Format, CDNURL: v.CDNURL})
+		dbVariants = append(dbVariants, store.MediaVariant{MediaID: id, Quality: v.Quality, Format: v.Format, Codec: v.Codec, StorageKey: v.StorageKey, Profile: v.Profile, Language: v.Language, Label: v.Label})
+		keep[variantIdentity(v.Quality, v.Format, v.Codec)] = true
 	}
-	return r.db.WithContext(ctx).Create(&dbVariants).Error
+	var previous []store.MediaVariant
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("media_id = ?", id).Find(&previous).Error; err != nil {
+			return err
+		}
+		if len(dbVariants) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "media_id"}, {Name: "quality"}, {Name: "format"}, {Name: "codec"}},
+				DoUpdates: clause.AssignmentColumns([]string{"storage_key", "profile", "language", "label"}),
+			}).Create(&dbVariants).Error; err != nil {
+				return err
+			}
+		}
+		var stale []uint
+		for _, v := range previous {
+			if !keep[variantIdentity(v.Quality, v.Format, v.Codec)] {
+				stale = append(stale, v.ID)
+			}
+		}
+		if len(stale) == 0 {
+			return nil
+		}
+		return tx.Delete(&store.MediaVariant{}, stale).Error
+	})
+	if err != nil {
+		return err
+	}
+	if len(previous) > 0 {
+		r.purge.Invalidate(ctx, id, PurgeReasonRetranscode, purgeKeys(id, previous))
+	}
+	return nil
+}
+
+// SetLoudness 记录默认音轨测得的积分响度，未测量时置空，避免保留上次转码的结果
+func (r *Repository) SetLoudness(ctx context.Context, id uint, lufs *float64) error {
+	return r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id = ?", id).Update("loudness", lufs).Error
 }
 
 func (r *Repository) GetAsset(ctx context.Context, id uint) (*store.MediaAsset, error) {
@@ -59,3 +213,266 @@ func (r *Repository) GetAsset(ctx context.Context, id uint) (*store.MediaAsset,
 	}
 	return &asset, nil
 }
+
+// ListByOwner 按 ID 倒序列出用户的资源，beforeID 为 0 时从最新开始；
+// 只预加载列表展示用到的封面与预览
+func (r *Repository) ListByOwner(ctx context.Context, ownerID string, beforeID uint, limit int) ([]store.MediaAsset, error) {
+	q := r.db.WithContext(ctx).
+		Preload("Variants", "format IN ?", []string{FormatPoster, FormatPreview}).
+		Where("owner_id = ?", ownerID)
+	if beforeID > 0 {
+		q = q.Where("id < ?", beforeID)
+	}
+	var assets []store.MediaAsset
+	err := q.Order("id DESC").Limit(limit).Find(&assets).Error
+	return assets, err
+}
+
+// DeleteAsset 删除资源及其 variant 记录并触发 CDN 刷新，存储中的文件由调用方清理
+func (r *Repository) DeleteAsset(ctx context.Context, asset *store.MediaAsset) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("media_id = ?", asset.ID).Delete(&store.MediaVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("media_id = ?", asset.ID).Delete(&store.MediaSubtitle{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&store.MediaAsset{}, asset.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+	r.purge.Invalidate(ctx, asset.ID, PurgeReasonDelete, purgeKeys(asset.ID, asset.Variants))
+	return nil
+}
+
+// ListReadyWithSourceBefore 返回在 before 之前已就绪且仍保留源文件的资源
+func (r *Repository) ListReadyWithSourceBefore(ctx context.Context, before time.Time, limit int) ([]store.MediaAsset, error) {
+	var assets []store.MediaAsset
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ? AND source_key <> ''", StatusReady, before).
+		Order("id").Limit(limit).Find(&assets).Error
+	return assets, err
+}
+
+// ListFailedBefore 按 ID 顺序返回 afterID 之后、在 before 之前转码失败且残留产物尚未清理的资源
+func (r *Repository) ListFailedBefore(ctx context.Context, before time.Time, afterID uint, limit int) ([]store.MediaAsset, error) {
+	var assets []store.MediaAsset
+	err := r.db.WithContext(ctx).Preload("Variants").
+		Where("status = ? AND updated_at < ? AND outputs_swept_at IS NULL AND id > ?", StatusFailed, before, afterID).
+		Order("id").Limit(limit).Find(&assets).Error
+	return assets, err
+}
+
+// MarkOutputsSwept 记录失败资源的残留产物已清理；不更新 updated_at
+func (r *Repository) MarkOutputsSwept(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id = ?", id).UpdateColumn("outputs_swept_at", time.Now()).Error
+}
+
+// ClearSourceKey 源文件按保留策略删除后清空 key；不更新 updated_at，避免影响其他基于时间的策略
+func (r *Repository) ClearSourceKey(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id = ?", id).UpdateColumn("source_key", "").Error
+}
+
+// AdoptSourceKey 为尚未记录源文件的资源补录 key，已有 key 时不覆盖；不更新 updated_at
+func (r *Repository) AdoptSourceKey(ctx context.Context, id uint, key string) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&store.MediaAsset{}).
+		Where("id = ? AND (source_key IS NULL OR source_key = '')", id).
+		UpdateColumn("source_key", key)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ExistingAssetIDs 返回 ids 中仍存在于 media_assets 的部分
+func (r *Repository) ExistingAssetIDs(ctx context.Context, ids []uint) (map[uint]bool, error) {
+	out := make(map[uint]bool, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var found []uint
+	if err := r.db.WithContext(ctx).Model(&store.MediaAsset{}).Where("id IN ?", ids).Pluck("id", &found).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range found {
+		out[id] = true
+	}
+	return out, nil
+}
+
+// ReferencedSourceKeys 返回 keys 中仍被资源或字幕轨引用的源文件 key
+func (r *Repository) ReferencedSourceKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	out := make(map[string]bool, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	for _, model := range []any{&store.MediaAsset{}, &store.MediaSubtitle{}} {
+		var found []string
+		if err := r.db.WithContext(ctx).Model(model).Where("source_key IN ?", keys).Pluck("source_key", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, k := range found {
+			out[k] = true
+		}
+	}
+	return out, nil
+}
+
+// CreateSubtitle 记录用户上传的字幕轨
+func (r *Repository) CreateSubtitle(ctx context.Context, sub *store.MediaSubtitle) error {
+	return r.db.WithContext(ctx).Create(sub).Error
+}
+
+// UpsertEmbeddedSubtitle 按 (media_id, stream_index) 记录从源文件提取的字幕轨，重新转码时覆盖
+func (r *Repository) UpsertEmbeddedSubtitle(ctx context.Context, sub *store.MediaSubtitle) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "media_id"}, {Name: "stream_index"}},
+		DoUpdates: clause.AssignmentColumns([]string{"language", "label", "source_key", "updated_at"}),
+	}).Create(sub).Error
+}
+
+// ListSubtitles 按创建顺序返回资源的字幕轨
+func (r *Repository) ListSubtitles(ctx context.Context, mediaID uint) ([]store.MediaSubtitle, error) {
+	var subs []store.MediaSubtitle
+	err := r.db.WithContext(ctx).Where("media_id = ?", mediaID).Order("id").Find(&subs).Error
+	return subs, err
+}
+
+// SetSubtitleStorageKey 记录字幕轨打包后的媒体播放列表
+func (r *Repository) SetSubtitleStorageKey(ctx context.Context, id uint, key string) error {
+	return r.db.WithContext(ctx).Model(&store.MediaSubtitle{}).Where("id = ?", id).Update("storage_key", key).Error
+}
+
+// InvalidateSubtitles 字幕轨变更后刷新主播放列表与字幕目录
+func (r *Repository) InvalidateSubtitles(ctx context.Context, mediaID uint) {
+	prefix := OutputPrefix(mediaID)
+	r.purge.Invalidate(ctx, mediaID, PurgeReasonSubtitles, []string{prefix + "/index.m3u8", prefix + "/subs/"})
+}
+
+// StartJob 记录一次作业执行的开始，返回作业记录 ID
+func (r *Repository) StartJob(ctx context.Context, payload queue.JobPayload, messageID, consumer string) (uint, error) {
+	now := time.Now()
+	var retries int64
+	if err := r.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("media_id = ? AND state IN ?", payload.MediaID, store.JobExecutionStates).Count(&retries).Error; err != nil {
+		return 0, err
+	}
+	job := &store.TranscodeJob{
+		MediaID:        payload.MediaID,
+		State:          store.JobRunning,
+		RetryCount:     int(retries),
+		MessageID:      messageID,
+		IdempotencyKey: payload.IdempotencyKey,
+		Consumer:       consumer,
+		HeartbeatAt:    &now,
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 同一作业被重新认领时，之前失联的执行记录不会再结束，这里统一收尾
+		if payload.IdempotencyKey != "" {
+			if err := tx.Model(&store.TranscodeJob{}).
+				Where("idempotency_key = ? AND state = ?", payload.IdempotencyKey, store.JobRunning).
+				Updates(map[string]any{"state": store.JobFailed, "error": "superseded by redelivery"}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(job).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return job.ID, nil
+}
+
+// IsDuplicate 判断同一 key 的作业是否已成功完成，或正由其他 worker 执行（liveWithin 内有心跳）
+func (r *Repository) IsDuplicate(ctx context.Context, key string, liveWithin time.Duration) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+	var count int64
+	err := r.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("idempotency_key = ? AND (state = ? OR (state = ? AND heartbeat_at > ?))",
+			key, store.JobSucceeded, store.JobRunning, time.Now().Add(-liveWithin)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// HeartbeatJob 刷新作业心跳，巡检据此判断 worker 是否仍存活
+func (r *Repository) HeartbeatJob(ctx context.Context, jobID uint) error {
+	return r.db.WithContext(ctx).Model(&store.TranscodeJob{}).Where("id = ?", jobID).Update("heartbeat_at", time.Now()).Error
+}
+
+// FinishJob 记录作业结束状态
+func (r *Repository) FinishJob(ctx context.Context, jobID uint, jobErr error) error {
+	updates := map[string]any{"state": store.JobSucceeded, "heartbeat_at": time.Now()}
+	if jobErr != nil {
+		updates["state"] = store.JobFailed
+		updates["error"] = textutil.Truncate(jobErr.Error(), 1024)
+	}
+	return r.db.WithContext(ctx).Model(&store.TranscodeJob{}).Where("id = ?", jobID).Updates(updates).Error
+}
+
+// ListProcessingBefore 返回 before 之前最后更新、仍处于 PROCESSING 的资源
+func (r *Repository) ListProcessingBefore(ctx context.Context, before time.Time, limit int) ([]store.MediaAsset, error) {
+	var assets []store.MediaAsset
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", StatusProcessing, before).
+		Order("id").Limit(limit).Find(&assets).Error
+	return assets, err
+}
+
+// LatestJob 返回资源最近一次作业执行记录，不存在时返回 nil
+func (r *Repository) LatestJob(ctx context.Context, mediaID uint) (*store.TranscodeJob, error) {
+	var jobs []store.TranscodeJob
+	if err := r.db.WithContext(ctx).Where("media_id = ? AND state IN ?", mediaID, store.JobExecutionStates).
+		Order("id DESC").Limit(1).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// LatestOutbox 返回资源最近一条 outbox 记录，不存在时返回 nil
+func (r *Repository) LatestOutbox(ctx context.Context, mediaID uint) (*store.JobOutbox, error) {
+	var rows []store.JobOutbox
+	if err := r.db.WithContext(ctx).Where("media_id = ?", mediaID).Order("id DESC").Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+// Requeue 重新写入一条 outbox 记录以再次投递作业
+func (r *Repository) Requeue(ctx context.Context, job queue.JobPayload) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 刷新 updated_at，避免下一轮巡检在新作业开始前重复处置
+		if err := tx.Model(&store.MediaAsset{}).Where("id = ?", job.MediaID).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return insertOutbox(tx, job)
+	})
+}
+
+func variantIdentity(quality, format, codec string) string {
+	return quality + "/" + format + "/" + codec
+}
+
+// purgeKeys 重新转码会复用分片文件名，因此除清单外还需刷新整个输出目录
+func purgeKeys(id uint, variants []store.MediaVariant) []string {
+	keys := []string{OutputPrefix(id) + "/"}
+	for _, v := range variants {
+		if v.StorageKey != "" {
+			keys = append(keys, v.StorageKey)
+		}
+	}
+	return keys
+}
+
+func newIdempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -1,31 +1,48 @@
 package media
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
+	"parallel/internal/probe"
+	"parallel/internal/profile"
 	"parallel/internal/queue"
+	"parallel/internal/quota"
+	"parallel/internal/storage"
 	"parallel/pkg/api"
+	"parallel/pkg/auth"
 	"parallel/pkg/config"
 )
 
 type Service struct {
 	repo      *Repository
-	scheduler Scheduler
+	relay     JobNotifier
+	sources   storage.Storage
+	outputs   storage.Storage
+	quota     *quota.Service
+	prober    *probe.Prober
+	priority  PriorityPolicy
+	profiles  *profile.Registry
+	subtitles SubtitlePackager
 	cfg       config.Config
 }
 
-type Scheduler interface {
-	Submit(ctx context.Context, payload queue.JobPayload) error
+// JobNotifier 在作业写入 outbox 后唤醒 relay 尽快投递，投递本身由 relay 保证
+type JobNotifier interface {
+	Notify()
 }
 
 type uploadResponse struct {
@@ -33,12 +50,45 @@ type uploadResponse struct {
 }
 
 type playbackResponse struct {
-	Status   string    `json:"status"`
-	Variants []Variant `json:"variants"`
+	Status     string    `json:"status"`
+	FailReason string    `json:"failReason,omitempty"`
+	Variants   []Variant `json:"variants"`
+	Poster     string    `json:"poster,omitempty"`
+	Thumbnails string    `json:"thumbnails,omitempty"` // 逐张缩略图的 WebVTT 索引
+	Trickplay  string    `json:"trickplay,omitempty"`  // 雪碧图的 WebVTT 索引，用于拖动进度条预览
+	Preview    string    `json:"preview,omitempty"`    // 静音悬停预览
+	Loudness   *float64  `json:"loudness,omitempty"`   // 默认音轨的积分响度（LUFS）
+	// AudioTracks 备选音轨，HLS 主播放列表中已登记为 AUDIO 组，第一条为默认音轨
+	AudioTracks []audioTrackResponse `json:"audioTracks"`
+	// Subtitles 已打包的字幕轨，HLS 主播放列表中已登记为 SUBTITLES 组
+	Subtitles []subtitleResponse `json:"subtitles"`
 }
 
-func NewService(repo *Repository, scheduler Scheduler, cfg config.Config) *Service {
-	return &Service{repo: repo, scheduler: scheduler, cfg: cfg}
+type audioTrackResponse struct {
+	Language string `json:"language"`
+	Label    string `json:"label"`
+	Codec    string `json:"codec,omitempty"`
+	Default  bool   `json:"default"`
+	CDNURL   string `json:"cdnUrl"`
+}
+
+type listItem struct {
+	MediaID   uint      `json:"mediaId"`
+	Status    string    `json:"status"`
+	Duration  float64   `json:"duration"`
+	CreatedAt time.Time `json:"createdAt"`
+	Poster    string    `json:"poster,omitempty"`
+	Preview   string    `json:"preview,omitempty"`
+}
+
+type listResponse struct {
+	Items []listItem `json:"items"`
+	// NextBefore 作为下一页的 before 参数，没有更多数据时省略
+	NextBefore uint `json:"nextBefore,omitempty"`
+}
+
+func NewService(repo *Repository, relay JobNotifier, sources, outputs storage.Storage, quota *quota.Service, prober *probe.Prober, priority PriorityPolicy, profiles *profile.Registry, subtitles SubtitlePackager, cfg config.Config) *Service {
+	return &Service{repo: repo, relay: relay, sources: sources, outputs: outputs, quota: quota, prober: prober, priority: priority, profiles: profiles, subtitles: subtitles, cfg: cfg}
 }
 
 func (s *Service) HandleUpload(c *gin.Context) {
@@ -48,32 +98,70 @@ func (s *Service) HandleUpload(c *gin.Context) {
 		return
 	}
 	ownerID := s.ownerIDFromContext(c)
-
-	destName := fmt.Sprintf("upload-%d-%s", time.Now().UnixNano(), sanitizeFilename(file.Filename))
-	destPath := filepath.Join(s.cfg.UploadDir, destName)
-	if err := c.SaveUploadedFile(file, destPath); err != nil {
-		c.JSON(http.StatusInternalServerError, api.Error("保存文件失败"))
+	plan := auth.Plan(c)
+	prio, err := s.priority.Check(plan, auth.Role(c), c.PostForm("priority"))
+	if err != nil {
+		s.respondPriorityError(c, err)
+		return
+	}
+	profileName, err := s.profiles.Resolve(strings.TrimSpace(c.PostForm("profile")))
+	if err != nil {
+		s.respondProfileError(c, err)
+		return
+	}
+	watermark, err := s.watermarkOverride(profileName, []byte(c.PostForm("watermark")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error(err.Error()))
 		return
 	}
 
 	reqCtx := c.Request.Context()
-	mediaID, err := s.repo.CreateAsset(reqCtx, ownerID, destPath)
+	if err := s.quota.CheckTranscode(reqCtx, ownerID); err != nil {
+		s.respondQuotaError(c, err)
+		return
+	}
+	// 写入前原子地占用存储配额，源文件写入失败时归还
+	if err := s.quota.ReserveBytes(reqCtx, ownerID, file.Size); err != nil {
+		s.respondQuotaError(c, err)
+		return
+	}
+	duration := s.probeUpload(reqCtx, file)
+	if prio == "" {
+		prio = s.priority.Auto(plan, duration)
+	}
+	destKey := fmt.Sprintf("upload-%d-%s", time.Now().UnixNano(), sanitizeFilename(file.Filename))
+	src, err := file.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, api.Error("记录资源失败"))
+		_ = s.quota.AddBytes(context.WithoutCancel(reqCtx), ownerID, -file.Size)
+		c.JSON(http.StatusBadRequest, api.Error("读取文件失败"))
 		return
 	}
-	payload := queue.JobPayload{MediaID: mediaID, Source: destPath}
-	if err := s.scheduler.Submit(context.Background(), payload); err != nil {
-		c.JSON(http.StatusInternalServerError, api.Error("投递转码任务失败"))
+	defer src.Close()
+	if err := s.sources.Put(reqCtx, destKey, src, file.Size, file.Header.Get("Content-Type")); err != nil {
+		_ = s.quota.AddBytes(context.WithoutCancel(reqCtx), ownerID, -file.Size)
+		c.JSON(http.StatusInternalServerError, api.Error("保存文件失败"))
 		return
 	}
+
+	payload := queue.JobPayload{OwnerID: ownerID, Source: destKey, Priority: prio, Profile: profileName, Watermark: watermark}
+	mediaID, err := s.repo.CreateAssetWithJob(reqCtx, ownerID, destKey, destKey, duration, payload)
+	if err != nil {
+		s.discardSource(context.WithoutCancel(reqCtx), ownerID, destKey, file.Size)
+		c.JSON(http.StatusInternalServerError, api.Error("记录资源失败"))
+		return
+	}
+	s.relay.Notify()
 	status, body := api.Accepted(uploadResponse{MediaID: mediaID})
 	c.JSON(status, body)
 }
 
 func (s *Service) HandleRemoteFetch(c *gin.Context) {
 	var req struct {
-		URL string `json:"url"`
+		URL      string `json:"url"`
+		Priority string `json:"priority"`
+		Profile  string `json:"profile"`
+		// Watermark 按资源覆盖的水印参数，格式同上传接口的 watermark 字段
+		Watermark json.RawMessage `json:"watermark"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, api.Error("请求格式错误"))
@@ -85,13 +173,35 @@ func (s *Service) HandleRemoteFetch(c *gin.Context) {
 		return
 	}
 	ownerID := s.ownerIDFromContext(c)
+	plan := auth.Plan(c)
+	prio, err := s.priority.Check(plan, auth.Role(c), req.Priority)
+	if err != nil {
+		s.respondPriorityError(c, err)
+		return
+	}
+	profileName, err := s.profiles.Resolve(strings.TrimSpace(req.Profile))
+	if err != nil {
+		s.respondProfileError(c, err)
+		return
+	}
+	watermark, err := s.watermarkOverride(profileName, req.Watermark)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error(err.Error()))
+		return
+	}
 	reqCtx := c.Request.Context()
-	mediaID, err := s.repo.CreateAsset(reqCtx, ownerID, req.URL)
+	// 远程文件大小未知，这里只拒绝配额已用尽的请求，下载过程中再按剩余额度截断
+	if err := s.checkQuota(reqCtx, ownerID, 0); err != nil {
+		s.respondQuotaError(c, err)
+		return
+	}
+	mediaID, err := s.repo.CreateAsset(reqCtx, ownerID, req.URL, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, api.Error("记录资源失败"))
 		return
 	}
-	go s.fetchAndSchedule(context.Background(), mediaID, req.URL)
+	job := queue.JobPayload{OwnerID: ownerID, Priority: prio, Profile: profileName, Watermark: watermark}
+	go s.fetchAndSchedule(context.Background(), mediaID, req.URL, plan, job)
 	status, body := api.Accepted(uploadResponse{MediaID: mediaID})
 	c.JSON(status, body)
 }
@@ -107,21 +217,149 @@ func (s *Service) HandlePlaybackDescriptor(c *gin.Context) {
 		c.JSON(http.StatusNotFound, api.Error("资源不存在"))
 		return
 	}
-	variants := make([]Variant, 0, len(asset.Variants))
+	resp := playbackResponse{Status: asset.Status, FailReason: asset.FailReason, Loudness: asset.Loudness, Variants: make([]Variant, 0, len(asset.Variants))}
+	audio := map[int]audioTrackResponse{}
 	for _, v := range asset.Variants {
-		variants = append(variants, Variant{Quality: v.Quality, Format: v.Format, CDNURL: v.CDNURL})
+		url := s.outputs.URL(v.StorageKey)
+		switch v.Format {
+		case FormatPoster:
+			resp.Poster = url
+		case FormatThumbnails:
+			resp.Thumbnails = url
+		case FormatTrickplay:
+			resp.Trickplay = url
+		case FormatPreview:
+			resp.Preview = url
+		case FormatAudio:
+			// quality 为 audio-<序号>，按序号还原主播放列表中的顺序
+			if n, err := strconv.Atoi(strings.TrimPrefix(v.Quality, "audio-")); err == nil {
+				audio[n] = audioTrackResponse{Language: v.Language, Label: v.Label, Codec: v.Codec, Default: n == 0, CDNURL: url}
+			}
+		default:
+			resp.Variants = append(resp.Variants, Variant{Quality: v.Quality, Format: v.Format, Codec: v.Codec, CDNURL: url, Profile: v.Profile})
+		}
+	}
+	resp.AudioTracks = make([]audioTrackResponse, 0, len(audio))
+	for n := 0; n < len(audio); n++ {
+		if t, ok := audio[n]; ok {
+			resp.AudioTracks = append(resp.AudioTracks, t)
+		}
+	}
+	subs, err := s.repo.ListSubtitles(c.Request.Context(), asset.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("查询字幕失败"))
+		return
+	}
+	resp.Subtitles = make([]subtitleResponse, 0, len(subs))
+	for _, sub := range subs {
+		if sub.StorageKey != "" {
+			resp.Subtitles = append(resp.Subtitles, s.subtitleView(sub))
+		}
 	}
-	status, body := api.Ok(playbackResponse{Status: asset.Status, Variants: variants})
+	status, body := api.Ok(resp)
 	c.JSON(status, body)
 }
 
-func (s *Service) fetchAndSchedule(ctx context.Context, mediaID uint, rawURL string) {
-	if err := s.downloadToUpload(ctx, mediaID, rawURL); err != nil {
-		_ = s.repo.UpdateStatus(ctx, mediaID, StatusFailed)
+// HandleList 分页列出当前用户的资源，附带封面与悬停预览
+func (s *Service) HandleList(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		c.JSON(http.StatusBadRequest, api.Error("limit 取值范围为 1-100"))
+		return
+	}
+	before, err := strconv.ParseUint(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error("before 非法"))
+		return
+	}
+	assets, err := s.repo.ListByOwner(c.Request.Context(), s.ownerIDFromContext(c), uint(before), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("查询资源失败"))
+		return
+	}
+	resp := listResponse{Items: make([]listItem, 0, len(assets))}
+	for _, asset := range assets {
+		item := listItem{MediaID: asset.ID, Status: asset.Status, Duration: asset.Duration, CreatedAt: asset.CreatedAt}
+		for _, v := range asset.Variants {
+			switch v.Format {
+			case FormatPoster:
+				item.Poster = s.outputs.URL(v.StorageKey)
+			case FormatPreview:
+				item.Preview = s.outputs.URL(v.StorageKey)
+			}
+		}
+		resp.Items = append(resp.Items, item)
 	}
+	if len(assets) == limit {
+		resp.NextBefore = assets[len(assets)-1].ID
+	}
+	status, body := api.Ok(resp)
+	c.JSON(status, body)
 }
 
-func (s *Service) downloadToUpload(ctx context.Context, mediaID uint, rawURL string) error {
+// HandleDelete 删除资源记录、源文件与转码产物，CDN 刷新由仓储层触发
+func (s *Service) HandleDelete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error("ID 非法"))
+		return
+	}
+	reqCtx := c.Request.Context()
+	asset, err := s.repo.GetAsset(reqCtx, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, api.Error("资源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, api.Error("查询资源失败"))
+		return
+	}
+	if asset.OwnerID != s.ownerIDFromContext(c) {
+		c.JSON(http.StatusForbidden, api.Error("无权删除该资源"))
+		return
+	}
+	if asset.Status == StatusProcessing {
+		c.JSON(http.StatusConflict, api.Error("资源转码中，暂不可删除"))
+		return
+	}
+	subs, err := s.repo.ListSubtitles(reqCtx, asset.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("查询字幕失败"))
+		return
+	}
+	if err := s.repo.DeleteAsset(reqCtx, asset); err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("删除资源失败"))
+		return
+	}
+	// 记录已删除，存储清理失败只会留下无人引用的文件，不影响接口结果
+	freed, _ := storage.DeletePrefix(reqCtx, s.outputs, OutputPrefix(asset.ID)+"/")
+	sourceKeys := []string{asset.SourceKey}
+	for _, sub := range subs {
+		sourceKeys = append(sourceKeys, sub.SourceKey)
+	}
+	for _, key := range sourceKeys {
+		if key == "" {
+			continue
+		}
+		if info, err := s.sources.Stat(reqCtx, key); err == nil {
+			if s.sources.Delete(reqCtx, key) == nil {
+				freed += info.Size
+			}
+		}
+	}
+	_ = s.quota.AddBytes(reqCtx, asset.OwnerID, -freed)
+	c.Status(http.StatusNoContent)
+}
+
+// fetchAndSchedule job 为待投递的作业（不含源文件），优先级为空时在下载完成后按时长推断
+func (s *Service) fetchAndSchedule(ctx context.Context, mediaID uint, rawURL, plan string, job queue.JobPayload) {
+	if err := s.downloadToUpload(ctx, mediaID, rawURL, plan, job); err != nil {
+		_ = s.repo.MarkFailed(ctx, mediaID, fmt.Sprintf("远程拉取失败: %v", err))
+	}
+}
+
+func (s *Service) downloadToUpload(ctx context.Context, mediaID uint, rawURL, plan string, job queue.JobPayload) error {
+	ownerID := job.OwnerID
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return err
@@ -134,22 +372,162 @@ func (s *Service) downloadToUpload(ctx context.Context, mediaID uint, rawURL str
 	if resp.StatusCode >= 400 {
 		return fmt.Errorf("下载失败: status=%d", resp.StatusCode)
 	}
-	name := fmt.Sprintf("remote-%d-%d.mp4", mediaID, time.Now().UnixNano())
-	dest := filepath.Join(s.cfg.UploadDir, name)
-	f, err := os.Create(dest)
+	var body io.Reader = resp.Body
+	remaining, limited, err := s.quota.RemainingBytes(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if limited {
+		if resp.ContentLength > remaining {
+			return quota.ErrStorageExceeded
+		}
+		body = &quotaReader{r: resp.Body, remaining: remaining}
+	}
+	// 先落到本地工作目录，便于探测时长后再写入存储
+	tmp, err := os.CreateTemp(s.cfg.WorkDir, "remote-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	duration := s.probeDuration(ctx, tmp.Name())
+	if job.Priority == "" {
+		job.Priority = s.priority.Auto(plan, duration)
+	}
+	info, err := os.Stat(tmp.Name())
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	if err := s.quota.ReserveBytes(ctx, ownerID, info.Size()); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("remote-%d-%d.mp4", mediaID, time.Now().UnixNano())
+	if _, err := storage.PutFile(ctx, s.sources, key, tmp.Name()); err != nil {
+		_ = s.quota.AddBytes(ctx, ownerID, -info.Size())
+		return err
+	}
+	job.Source = key
+	if err := s.repo.SetSourceAndEnqueue(ctx, mediaID, key, duration, job); err != nil {
+		s.discardSource(ctx, ownerID, key, info.Size())
 		return err
 	}
-	payload := queue.JobPayload{MediaID: mediaID, Source: dest}
-	return s.scheduler.Submit(context.Background(), payload)
+	s.relay.Notify()
+	return nil
+}
+
+// discardSource 源文件已写入但记录失败时删除文件并归还占用的配额，
+// 否则孤儿清理虽能删掉文件，却不知道归属者而无法退还配额
+func (s *Service) discardSource(ctx context.Context, ownerID, key string, size int64) {
+	_ = s.sources.Delete(ctx, key)
+	_ = s.quota.AddBytes(ctx, ownerID, -size)
 }
 
 func (s *Service) ownerIDFromContext(c *gin.Context) string {
-	return "demo-user"
+	return auth.OwnerID(c)
+}
+
+// checkQuota 校验存储与转码时长配额，incoming 为即将写入的字节数
+func (s *Service) checkQuota(ctx context.Context, ownerID string, incoming int64) error {
+	if err := s.quota.CheckStorage(ctx, ownerID, incoming); err != nil {
+		return err
+	}
+	return s.quota.CheckTranscode(ctx, ownerID)
+}
+
+func (s *Service) respondQuotaError(c *gin.Context, err error) {
+	if errors.Is(err, quota.ErrStorageExceeded) || errors.Is(err, quota.ErrTranscodeExceeded) {
+		c.JSON(http.StatusForbidden, api.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, api.Error("查询配额失败"))
+}
+
+func (s *Service) respondPriorityError(c *gin.Context, err error) {
+	if errors.Is(err, ErrPriorityForbidden) {
+		c.JSON(http.StatusForbidden, api.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusBadRequest, api.Error(err.Error()))
+}
+
+// watermarkOverride 校验按资源覆盖的水印参数并返回压缩后的 JSON，raw 为空或 null 时返回空串
+func (s *Service) watermarkOverride(profileName string, raw []byte) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", fmt.Errorf("%w: %v", profile.ErrInvalidWatermark, err)
+	}
+	prof, err := s.profiles.Get(profileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := prof.WithWatermark(compact.String()); err != nil {
+		return "", err
+	}
+	return compact.String(), nil
+}
+
+func (s *Service) respondProfileError(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, api.Error(fmt.Sprintf("%v，可选: %s", err, strings.Join(s.profiles.Names(), ", "))))
+}
+
+// probeUpload 探测上传文件的时长。大文件已由 multipart 落盘，直接读取；
+// 内存中的小文件先写入工作目录
+func (s *Service) probeUpload(ctx context.Context, file *multipart.FileHeader) float64 {
+	src, err := file.Open()
+	if err != nil {
+		return 0
+	}
+	defer src.Close()
+	if f, ok := src.(*os.File); ok {
+		return s.probeDuration(ctx, f.Name())
+	}
+	tmp, err := os.CreateTemp(s.cfg.WorkDir, "probe-*")
+	if err != nil {
+		return 0
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return 0
+	}
+	return s.probeDuration(ctx, tmp.Name())
+}
+
+// probeDuration 探测失败时返回 0，优先级按未知时长处理，由转码阶段暴露文件问题
+func (s *Service) probeDuration(ctx context.Context, path string) float64 {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	res, err := s.prober.Probe(ctx, path)
+	if err != nil {
+		return 0
+	}
+	return res.Duration
+}
+
+// quotaReader 下载远程文件时超出剩余存储配额即中断
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.remaining -= int64(n)
+	if q.remaining < 0 {
+		return n, quota.ErrStorageExceeded
+	}
+	return n, err
 }
 
 func sanitizeFilename(name string) string {
@@ -0,0 +1,153 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"parallel/internal/store"
+	"parallel/pkg/api"
+)
+
+// SubtitlePackager 把资源的全部字幕轨打包为分段 WebVTT 并写入主播放列表
+type SubtitlePackager interface {
+	PackageSubtitles(ctx context.Context, mediaID uint) error
+}
+
+// subtitleExts 允许上传的字幕格式
+var subtitleExts = map[string]bool{".srt": true, ".vtt": true, ".ass": true, ".ssa": true}
+
+// languagePattern BCP 47 形式的语言标签，如 zh、en-US、zh-Hans
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8}){0,2}$`)
+
+// maxSubtitleSize 字幕文件大小上限
+const maxSubtitleSize = 5 << 20
+
+type subtitleResponse struct {
+	ID       uint   `json:"id"`
+	Language string `json:"language"`
+	Label    string `json:"label"`
+	Origin   string `json:"origin"`
+	CDNURL   string `json:"cdnUrl,omitempty"` // 分段 WebVTT 的媒体播放列表，打包前为空
+	VTT      string `json:"vtt,omitempty"`    // 完整的 WebVTT，供 DASH 或原生 <track> 使用
+}
+
+// HandleUploadSubtitle 为资源上传字幕。资源已就绪时立即打包，否则在转码完成后一并打包
+func (s *Service) HandleUploadSubtitle(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error("ID 非法"))
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.Error("文件缺失"))
+		return
+	}
+	ext := strings.ToLower(path.Ext(file.Filename))
+	if !subtitleExts[ext] {
+		c.JSON(http.StatusBadRequest, api.Error("字幕格式不支持，可选: srt, vtt, ass, ssa"))
+		return
+	}
+	if file.Size > maxSubtitleSize {
+		c.JSON(http.StatusBadRequest, api.Error("字幕文件过大"))
+		return
+	}
+	language := strings.TrimSpace(c.PostForm("language"))
+	if !languagePattern.MatchString(language) {
+		c.JSON(http.StatusBadRequest, api.Error("language 非法，应为 BCP 47 语言标签，如 zh-Hans、en"))
+		return
+	}
+	label := strings.TrimSpace(c.PostForm("label"))
+	if label == "" {
+		label = language
+	}
+	if len([]rune(label)) > 64 {
+		c.JSON(http.StatusBadRequest, api.Error("label 过长"))
+		return
+	}
+
+	reqCtx := c.Request.Context()
+	asset, err := s.repo.GetAsset(reqCtx, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, api.Error("资源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, api.Error("查询资源失败"))
+		return
+	}
+	ownerID := s.ownerIDFromContext(c)
+	if asset.OwnerID != ownerID {
+		c.JSON(http.StatusForbidden, api.Error("无权修改该资源"))
+		return
+	}
+	if asset.Status == StatusFailed {
+		c.JSON(http.StatusConflict, api.Error("资源转码失败，无法添加字幕"))
+		return
+	}
+	if err := s.quota.ReserveBytes(reqCtx, ownerID, file.Size); err != nil {
+		s.respondQuotaError(c, err)
+		return
+	}
+	key := fmt.Sprintf("subtitle-%d-%d%s", asset.ID, time.Now().UnixNano(), ext)
+	src, err := file.Open()
+	if err != nil {
+		_ = s.quota.AddBytes(context.WithoutCancel(reqCtx), ownerID, -file.Size)
+		c.JSON(http.StatusBadRequest, api.Error("读取文件失败"))
+		return
+	}
+	defer src.Close()
+	if err := s.sources.Put(reqCtx, key, src, file.Size, "text/plain"); err != nil {
+		_ = s.quota.AddBytes(context.WithoutCancel(reqCtx), ownerID, -file.Size)
+		c.JSON(http.StatusInternalServerError, api.Error("保存文件失败"))
+		return
+	}
+	sub := &store.MediaSubtitle{MediaID: asset.ID, Language: language, Label: label, Origin: store.SubtitleUpload, SourceKey: key}
+	if err := s.repo.CreateSubtitle(reqCtx, sub); err != nil {
+		s.discardSource(context.WithoutCancel(reqCtx), ownerID, key, file.Size)
+		c.JSON(http.StatusInternalServerError, api.Error("记录字幕失败"))
+		return
+	}
+	if asset.Status != StatusReady {
+		// 转码可能在记录字幕前后恰好完成：重新读取状态，仍未就绪时由转码结束后的补打包处理
+		current, err := s.repo.GetAsset(reqCtx, asset.ID)
+		if err != nil || current.Status != StatusReady {
+			status, body := api.Accepted(s.subtitleView(*sub))
+			c.JSON(status, body)
+			return
+		}
+	}
+	if err := s.subtitles.PackageSubtitles(reqCtx, asset.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("字幕打包失败，将在下次转码时重试"))
+		return
+	}
+	if subs, err := s.repo.ListSubtitles(reqCtx, asset.ID); err == nil {
+		for _, packaged := range subs {
+			if packaged.ID == sub.ID {
+				sub = &packaged
+				break
+			}
+		}
+	}
+	status, body := api.Ok(s.subtitleView(*sub))
+	c.JSON(status, body)
+}
+
+func (s *Service) subtitleView(sub store.MediaSubtitle) subtitleResponse {
+	view := subtitleResponse{ID: sub.ID, Language: sub.Language, Label: sub.Label, Origin: sub.Origin}
+	if sub.StorageKey != "" {
+		view.CDNURL = s.outputs.URL(sub.StorageKey)
+		view.VTT = s.outputs.URL(path.Dir(sub.StorageKey) + "/full.vtt")
+	}
+	return view
+}
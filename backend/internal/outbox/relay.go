@@ -0,0 +1,158 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"parallel/internal/queue"
+	"parallel/internal/store"
+	"parallel/pkg/textutil"
+)
+
+// Publisher 将作业发布到队列，返回消息 ID
+type Publisher interface {
+	Submit(ctx context.Context, payload queue.JobPayload) (string, error)
+}
+
+// Relay 轮询 job_outboxes 中的 PENDING 记录并发布到队列，成功后标记 SENT。
+// 发布成功但标记失败时会重复发布，即至少一次投递，重复作业由消费端幂等处理。
+// 发布失败的记录按尝试次数退避，不阻塞同批其他记录；无法解析的记录标记 DEAD。
+type Relay struct {
+	db        *gorm.DB
+	publisher Publisher
+	logger    *log.Logger
+	interval  time.Duration
+	retention time.Duration
+	batchSize int
+	wake      chan struct{}
+}
+
+func NewRelay(db *gorm.DB, publisher Publisher, logger *log.Logger, interval, retention time.Duration) *Relay {
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		logger:    logger,
+		interval:  interval,
+		retention: retention,
+		batchSize: 50,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify 唤醒 relay 立即处理一轮，非阻塞
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) Start(ctx context.Context) {
+	go r.loop(ctx)
+}
+
+func (r *Relay) loop(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				r.logger.Printf("outbox relay error: %v", err)
+				break
+			}
+			if n < r.batchSize {
+				break
+			}
+		}
+		if r.retention > 0 && time.Since(lastCleanup) > time.Hour {
+			r.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// relayBatch 用 SKIP LOCKED 领取一批记录，多实例同时运行时互不阻塞
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	var sent int
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []store.JobOutbox
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", store.OutboxPending, now).
+			Order("id").Limit(r.batchSize).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			payload, err := queue.Decode([]byte(row.Payload))
+			if err != nil {
+				r.logger.Printf("outbox row %d undecodable, marking dead: %v", row.ID, err)
+				if err := tx.Model(&store.JobOutbox{}).Where("id = ?", row.ID).Updates(map[string]any{
+					"status":     store.OutboxDead,
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": textutil.Truncate(err.Error(), 1024),
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			messageID, err := r.publisher.Submit(ctx, payload)
+			if err != nil {
+				// 单条失败只推迟这一条，其余记录照常发布
+				next := now.Add(retryBackoff(row.Attempts + 1))
+				r.logger.Printf("outbox publish %d error (attempt %d, retry at %s): %v", row.ID, row.Attempts+1, next.Format(time.RFC3339), err)
+				if err := tx.Model(&store.JobOutbox{}).Where("id = ?", row.ID).Updates(map[string]any{
+					"attempts":        gorm.Expr("attempts + 1"),
+					"last_error":      textutil.Truncate(err.Error(), 1024),
+					"next_attempt_at": &next,
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			sentAt := time.Now()
+			if err := tx.Model(&store.JobOutbox{}).Where("id = ?", row.ID).Updates(map[string]any{
+				"status":     store.OutboxSent,
+				"message_id": messageID,
+				"sent_at":    &sentAt,
+			}).Error; err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// retryBackoff 第 attempt 次发布失败后的等待时间：从 2 秒开始指数增长，最长 5 分钟
+func retryBackoff(attempt int) time.Duration {
+	d := 2 * time.Second
+	for i := 1; i < attempt && d < 5*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 5*time.Minute {
+		d = 5 * time.Minute
+	}
+	return d
+}
+
+// cleanup 删除保留期之外的已发送记录
+func (r *Relay) cleanup(ctx context.Context) {
+	before := time.Now().Add(-r.retention)
+	if err := r.db.WithContext(ctx).Where("status = ? AND sent_at < ?", store.OutboxSent, before).
+		Delete(&store.JobOutbox{}).Error; err != nil {
+		r.logger.Printf("outbox cleanup error: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Stream ffprobe 输出中的单条流信息
+type Stream struct {
+	Index     int               `json:"index"`
+	CodecType string            `json:"codec_type"`
+	CodecName string            `json:"codec_name"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Channels  int               `json:"channels"`
+	Tags      map[string]string `json:"tags"`
+	// Disposition 如 {"default": 1, "comment": 0}
+	Disposition map[string]int `json:"disposition"`
+}
+
+// Result ffprobe 的探测结果
+type Result struct {
+	Duration float64
+	Streams  []Stream
+}
+
+// StreamsOf 按类型（video / audio / subtitle）筛选流，保持源文件中的顺序
+func (r *Result) StreamsOf(codecType string) []Stream {
+	var out []Stream
+	for _, s := range r.Streams {
+		if s.CodecType == codecType {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Prober 调用 ffprobe 读取媒体时长与流信息
+type Prober struct {
+	binary string
+}
+
+func New(binary string) *Prober {
+	return &Prober{binary: binary}
+}
+
+func (p *Prober) Probe(ctx context.Context, input string) (*Result, error) {
+	cmd := exec.CommandContext(ctx, p.binary,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		input,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe 失败: %v, stderr=%s", err, stderr.String())
+	}
+	var out struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []Stream `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, err
+	}
+	duration, _ := strconv.ParseFloat(out.Format.Duration, 64)
+	return &Result{Duration: duration, Streams: out.Streams}, nil
+}
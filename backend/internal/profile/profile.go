@@ -0,0 +1,302 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// DefaultName 内置转码配置的名称，与早期固定参数（h264 veryfast 4000k、4 秒 TS 分片）一致
+const DefaultName = "default"
+
+// 分片容器
+const (
+	ContainerTS   = "ts"
+	ContainerFMP4 = "fmp4"
+)
+
+// 打包方式：hls 只输出 HLS；cmaf 以 fMP4 分片打包一次，同时输出 HLS 主播放列表与 DASH MPD
+const (
+	PackagingHLS  = "hls"
+	PackagingCMAF = "cmaf"
+)
+
+// 视频编码族。h264 使用配置的 VideoCodec，hevc / av1 使用软件编码器，且只能封装为 fmp4
+const (
+	CodecH264 = "h264"
+	CodecHEVC = "hevc"
+	CodecAV1  = "av1"
+)
+
+// ErrUnknownProfile 请求或作业中的转码配置不存在
+var ErrUnknownProfile = errors.New("转码配置不存在")
+
+// Profile 一组转码参数：视频编码与码率阶梯、分片时长、音频参数、分片容器与打包方式
+type Profile struct {
+	Name           string `json:"name"`
+	VideoCodec     string `json:"videoCodec"` // ffmpeg 编码器名，如 h264 / libx264
+	Preset         string `json:"preset,omitempty"`
+	Container      string `json:"container"` // ts / fmp4，cmaf 打包固定为 fmp4
+	Packaging      string `json:"packaging"` // hls / cmaf
+	SegmentSeconds int    `json:"segmentSeconds"`
+	Audio          Audio  `json:"audio"`
+	Ladder         []Rung `json:"ladder"`
+	// Watermark 不为空时所有输出都叠加水印
+	Watermark *Watermark `json:"watermark,omitempty"`
+}
+
+// Audio 音频编码参数，数值为 0 时沿用编码器或源文件的默认值
+type Audio struct {
+	Codec      string `json:"codec"`
+	Bitrate    int    `json:"bitrate,omitempty"` // kbps
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+	// Languages 只保留语言标签（与源文件一致，如 eng、chi）在列表中的音轨，为空时保留全部音轨
+	Languages []string `json:"languages,omitempty"`
+	// ChannelLayout 输出声道布局（如 mono、stereo、5.1），多声道源按此下混，设置后忽略 Channels
+	ChannelLayout string `json:"channelLayout,omitempty"`
+	// CenterMixLevel 下混时中置声道的线性增益，0 表示使用默认值 0.707（-3dB），调高可突出对白
+	CenterMixLevel float64 `json:"centerMixLevel,omitempty"`
+	// Loudnorm 不为空时按 EBU R128 做两遍响度标准化：先测量，再按测量值线性调整
+	Loudnorm *Loudnorm `json:"loudnorm,omitempty"`
+}
+
+// Loudnorm 响度标准化目标，数值为 0 时使用 EBU R128 推荐值
+type Loudnorm struct {
+	Integrated float64 `json:"integrated,omitempty"` // 目标积分响度（LUFS），默认 -23
+	TruePeak   float64 `json:"truePeak,omitempty"`   // 真峰值上限（dBTP），默认 -1
+	Range      float64 `json:"range,omitempty"`      // 响度范围（LU），默认 7
+}
+
+// layoutChannels 支持的声道布局及其声道数
+var layoutChannels = map[string]int{
+	"mono":   1,
+	"stereo": 2,
+	"2.1":    3,
+	"3.0":    3,
+	"quad":   4,
+	"5.0":    5,
+	"5.1":    6,
+	"7.1":    8,
+}
+
+// OutputChannels 输出声道数，未指定时返回 0（沿用源文件）
+func (a Audio) OutputChannels() int {
+	if a.ChannelLayout != "" {
+		return layoutChannels[a.ChannelLayout]
+	}
+	return a.Channels
+}
+
+// Rung 码率阶梯中的一档，Name 作为 variant 的 quality；
+// 同名的档位可使用不同编码（如 1080p 同时输出 h264 与 hevc），以 (Name, Codec) 区分
+type Rung struct {
+	Name         string `json:"name"`
+	Codec        string `json:"codec,omitempty"`  // h264（默认）/ hevc / av1
+	Preset       string `json:"preset,omitempty"` // 覆盖配置的 Preset，av1 编码器使用数字档位
+	Height       int    `json:"height,omitempty"` // 0 表示保持源分辨率
+	VideoBitrate int    `json:"videoBitrate"`     // kbps
+	MaxBitrate   int    `json:"maxBitrate,omitempty"`
+}
+
+// Family 返回该档的视频编码族
+func (r Rung) Family() string {
+	if r.Codec == "" {
+		return CodecH264
+	}
+	return r.Codec
+}
+
+// Dir 该档的输出子目录，h264 沿用档位名，其余编码追加编码族以免同名档位冲突
+func (r Rung) Dir() string {
+	if r.Family() == CodecH264 {
+		return r.Name
+	}
+	return r.Name + "-" + r.Family()
+}
+
+// Bandwidth 估算该档的峰值码率（bit/s），用于主播放列表的 BANDWIDTH
+func (p Profile) Bandwidth(r Rung) int {
+	video := r.VideoBitrate
+	if r.MaxBitrate > video {
+		video = r.MaxBitrate
+	}
+	audio := p.Audio.Bitrate
+	if audio == 0 {
+		audio = 128
+	}
+	return (video + audio) * 1000
+}
+
+// Builtin 未配置任何转码配置时使用的默认配置
+func Builtin() Profile {
+	return Profile{
+		Name:           DefaultName,
+		VideoCodec:     "h264",
+		Preset:         "veryfast",
+		Container:      ContainerTS,
+		Packaging:      PackagingHLS,
+		SegmentSeconds: 4,
+		Audio:          Audio{Codec: "aac"},
+		Ladder:         []Rung{{Name: "1080p", VideoBitrate: 4000}},
+	}
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// normalize 补全默认值并校验，名称会用作存储路径，只允许小写字母、数字、- 与 _
+func (p *Profile) normalize() error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("转码配置名称非法: %q", p.Name)
+	}
+	if p.VideoCodec == "" {
+		p.VideoCodec = "h264"
+	}
+	if p.Container == "" {
+		p.Container = ContainerTS
+	}
+	if p.Container != ContainerTS && p.Container != ContainerFMP4 {
+		return fmt.Errorf("转码配置 %s 的 container 非法: %s", p.Name, p.Container)
+	}
+	switch p.Packaging {
+	case "":
+		p.Packaging = PackagingHLS
+	case PackagingHLS:
+	case PackagingCMAF:
+		p.Container = ContainerFMP4
+	default:
+		return fmt.Errorf("转码配置 %s 的 packaging 非法: %s", p.Name, p.Packaging)
+	}
+	if p.SegmentSeconds <= 0 {
+		p.SegmentSeconds = 4
+	}
+	if p.Audio.Codec == "" {
+		p.Audio.Codec = "aac"
+	}
+	if err := p.Audio.normalize(); err != nil {
+		return fmt.Errorf("转码配置 %s 的音频参数非法: %w", p.Name, err)
+	}
+	if p.Watermark != nil {
+		if err := p.Watermark.normalize(); err != nil {
+			return fmt.Errorf("转码配置 %s 的水印参数非法: %w", p.Name, err)
+		}
+	}
+	if len(p.Ladder) == 0 {
+		return fmt.Errorf("转码配置 %s 缺少码率阶梯", p.Name)
+	}
+	seen := map[string]bool{}
+	for _, r := range p.Ladder {
+		if !namePattern.MatchString(r.Name) || seen[r.Dir()] {
+			return fmt.Errorf("转码配置 %s 的阶梯名称非法或重复: %q", p.Name, r.Dir())
+		}
+		seen[r.Dir()] = true
+		if r.VideoBitrate <= 0 || r.Height < 0 {
+			return fmt.Errorf("转码配置 %s 的阶梯 %s 参数非法", p.Name, r.Name)
+		}
+		switch r.Family() {
+		case CodecH264:
+		case CodecHEVC, CodecAV1:
+			if p.Container != ContainerFMP4 {
+				return fmt.Errorf("转码配置 %s 的阶梯 %s 使用 %s 编码，container 必须为 fmp4", p.Name, r.Name, r.Codec)
+			}
+		default:
+			return fmt.Errorf("转码配置 %s 的阶梯 %s 编码非法: %s", p.Name, r.Name, r.Codec)
+		}
+	}
+	return nil
+}
+
+func (a *Audio) normalize() error {
+	if a.ChannelLayout != "" {
+		if _, ok := layoutChannels[a.ChannelLayout]; !ok {
+			return fmt.Errorf("不支持的 channelLayout: %s", a.ChannelLayout)
+		}
+	}
+	if a.CenterMixLevel < 0 || a.CenterMixLevel > 4 {
+		return fmt.Errorf("centerMixLevel 超出范围: %v", a.CenterMixLevel)
+	}
+	if n := a.Loudnorm; n != nil {
+		if n.Integrated == 0 {
+			n.Integrated = -23
+		}
+		if n.TruePeak == 0 {
+			n.TruePeak = -1
+		}
+		if n.Range == 0 {
+			n.Range = 7
+		}
+		// loudnorm 滤镜的取值范围
+		if n.Integrated < -70 || n.Integrated > -5 || n.TruePeak < -9 || n.TruePeak > 0 || n.Range < 1 || n.Range > 50 {
+			return fmt.Errorf("loudnorm 参数超出范围: %+v", *n)
+		}
+	}
+	return nil
+}
+
+// Registry 按名称查找转码配置
+type Registry struct {
+	profiles map[string]Profile
+	def      string
+}
+
+// Load 在内置配置之上加载 path 中的 JSON 数组（path 为空时只有内置配置），
+// 同名配置覆盖内置配置；defaultName 为请求未指定时使用的配置
+func Load(path, defaultName string) (*Registry, error) {
+	r := &Registry{profiles: map[string]Profile{DefaultName: Builtin()}, def: defaultName}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var list []Profile
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("解析转码配置失败: %w", err)
+		}
+		for _, p := range list {
+			if err := p.normalize(); err != nil {
+				return nil, err
+			}
+			r.profiles[p.Name] = p
+		}
+	}
+	if r.def == "" {
+		r.def = DefaultName
+	}
+	if _, ok := r.profiles[r.def]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, r.def)
+	}
+	return r, nil
+}
+
+// Resolve 将请求中的配置名（可为空）解析为确定的名称，写入作业后不再受默认配置变更影响
+func (r *Registry) Resolve(name string) (string, error) {
+	if name == "" {
+		return r.def, nil
+	}
+	if _, ok := r.profiles[name]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	return name, nil
+}
+
+// Get 返回名称对应的配置，name 为空时返回默认配置（早期作业未携带配置名）
+func (r *Registry) Get(name string) (Profile, error) {
+	name, err := r.Resolve(name)
+	if err != nil {
+		return Profile{}, err
+	}
+	return r.profiles[name], nil
+}
+
+// Names 返回全部配置名称
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.profiles))
+	for name := range r.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// 水印位置
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+// ErrInvalidWatermark 上传时指定的水印参数非法
+var ErrInvalidWatermark = errors.New("水印参数非法")
+
+// 按资源覆盖时透明度与尺寸的下限，避免通过覆盖参数让水印实际不可见
+const (
+	minOverrideOpacity = 0.2
+	minOverrideScale   = 0.02
+)
+
+// overrideTextSeparator 覆盖文字追加在配置文字之后时使用的分隔
+const overrideTextSeparator = " · "
+
+// Watermark 叠加在视频画面上的图片和/或文字水印，尺寸均相对于输出高度，各档码率观感一致。
+// Text 中的 {owner} 与 {media} 在转码时替换为上传者 ID 与资源 ID
+type Watermark struct {
+	Image    string  `json:"image,omitempty"` // 服务器本地的图片路径，建议带透明通道的 PNG
+	Text     string  `json:"text,omitempty"`
+	Font     string  `json:"font,omitempty"`     // drawtext 使用的字体文件，为空时使用系统默认字体
+	Position string  `json:"position,omitempty"` // top-left / top-right / bottom-left / bottom-right（默认）/ center
+	Opacity  float64 `json:"opacity,omitempty"`  // 0-1，默认 0.5
+	Scale    float64 `json:"scale,omitempty"`    // 图片高度与文字字号占输出高度的比例，默认 0.05
+	Margin   float64 `json:"margin,omitempty"`   // 与画面边缘的距离占输出高度的比例，默认 0.03
+}
+
+// WatermarkOverride 上传时按资源覆盖的水印参数。不能指定图片（服务器本地路径），
+// 也不能去掉或替换转码配置中已有的水印：Text 追加在配置文字之后，Opacity 与 Scale 不得低于下限
+type WatermarkOverride struct {
+	Text     *string `json:"text,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Scale    float64 `json:"scale,omitempty"`
+}
+
+// ExpandText 替换文字水印中的占位符
+func (w Watermark) ExpandText(ownerID string, mediaID uint) string {
+	return strings.NewReplacer("{owner}", ownerID, "{media}", fmt.Sprint(mediaID)).Replace(w.Text)
+}
+
+func (w *Watermark) normalize() error {
+	if w.Image == "" && w.Text == "" {
+		return errors.New("水印缺少图片或文字")
+	}
+	if w.Image != "" {
+		if _, err := os.Stat(w.Image); err != nil {
+			return fmt.Errorf("水印图片不可读: %w", err)
+		}
+	}
+	if utf8.RuneCountInString(w.Text) > 200 {
+		return errors.New("水印文字过长")
+	}
+	switch w.Position {
+	case "":
+		w.Position = PositionBottomRight
+	case PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionCenter:
+	default:
+		return fmt.Errorf("水印位置非法: %s", w.Position)
+	}
+	if w.Opacity == 0 {
+		w.Opacity = 0.5
+	}
+	if w.Scale == 0 {
+		w.Scale = 0.05
+	}
+	if w.Margin == 0 {
+		w.Margin = 0.03
+	}
+	if w.Opacity < 0 || w.Opacity > 1 || w.Scale < 0 || w.Scale > 0.5 || w.Margin < 0 || w.Margin > 0.2 {
+		return fmt.Errorf("水印参数超出范围: opacity=%v scale=%v margin=%v", w.Opacity, w.Scale, w.Margin)
+	}
+	return nil
+}
+
+// WithWatermark 返回应用了按资源覆盖参数的配置，override 为 JSON 形式的 WatermarkOverride，为空时原样返回
+func (p Profile) WithWatermark(override string) (Profile, error) {
+	if override == "" {
+		return p, nil
+	}
+	var o WatermarkOverride
+	dec := json.NewDecoder(bytes.NewReader([]byte(override)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return p, fmt.Errorf("%w: %v", ErrInvalidWatermark, err)
+	}
+	var w Watermark
+	if p.Watermark != nil {
+		w = *p.Watermark
+	}
+	if o.Text != nil && *o.Text != "" {
+		if w.Text != "" {
+			w.Text += overrideTextSeparator + *o.Text
+		} else {
+			w.Text = *o.Text
+		}
+	}
+	if o.Position != "" {
+		w.Position = o.Position
+	}
+	if o.Opacity != 0 {
+		if o.Opacity < minOverrideOpacity {
+			return p, fmt.Errorf("%w: opacity 不能低于 %v", ErrInvalidWatermark, minOverrideOpacity)
+		}
+		w.Opacity = o.Opacity
+	}
+	if o.Scale != 0 {
+		if o.Scale < minOverrideScale {
+			return p, fmt.Errorf("%w: scale 不能低于 %v", ErrInvalidWatermark, minOverrideScale)
+		}
+		w.Scale = o.Scale
+	}
+	if err := w.normalize(); err != nil {
+		return p, fmt.Errorf("%w: %v", ErrInvalidWatermark, err)
+	}
+	p.Watermark = &w
+	return p, nil
+}
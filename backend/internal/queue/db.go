@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"parallel/internal/store"
+)
+
+// DB 基于 MySQL transcode_jobs 表的队列实现，供不部署 Redis 的环境使用。
+// 消息以 QUEUED 状态写入，领取时用 SELECT ... FOR UPDATE SKIP LOCKED 抢占并置为 LEASED；
+// 租约空闲超过可见性超时后可被其他实例认领，ACK 即删除，死信置为 DEAD。仅支持单个消费组。
+type DB struct {
+	db           *gorm.DB
+	pollInterval time.Duration
+	notify       chan struct{}
+}
+
+var (
+	_ Queue     = (*DB)(nil)
+	_ Inspector = (*DB)(nil)
+)
+
+func NewDB(db *gorm.DB, pollInterval time.Duration) *DB {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &DB{db: db, pollInterval: pollInterval, notify: make(chan struct{}, 1)}
+}
+
+// Setup 表结构由 AutoMigrate 维护，这里无需处理
+func (d *DB) Setup(ctx context.Context, group string) error {
+	return nil
+}
+
+func (d *DB) Enqueue(ctx context.Context, payload JobPayload) (string, error) {
+	raw, err := Encode(payload)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	job := &store.TranscodeJob{
+		MediaID:        payload.MediaID,
+		State:          store.JobQueued,
+		IdempotencyKey: payload.IdempotencyKey,
+		Priority:       string(orNormal(payload.Priority)),
+		AvailableAt:    &now,
+		Payload:        string(raw),
+	}
+	if err := d.db.WithContext(ctx).Create(job).Error; err != nil {
+		return "", err
+	}
+	// 同进程内的消费者无需等到下一次轮询
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+	return strconv.FormatUint(uint64(job.ID), 10), nil
+}
+
+// Consume 全部为空时按 pollInterval 轮询，直到 block 超时
+func (d *DB) Consume(ctx context.Context, group, consumer string, priorities []Priority, block time.Duration) ([]Message, error) {
+	var deadline time.Time
+	if block > 0 {
+		deadline = time.Now().Add(block)
+	}
+	for {
+		var out []Message
+		for _, p := range priorities {
+			msg, err := d.lease(ctx, consumer, "state = ? AND priority = ? AND available_at <= ?",
+				store.JobQueued, string(orNormal(p)), time.Now())
+			if err != nil {
+				return out, err
+			}
+			if msg != nil {
+				out = append(out, *msg)
+			}
+		}
+		if len(out) > 0 || block <= 0 || !time.Now().Before(deadline) {
+			return out, nil
+		}
+		wait := time.NewTimer(minDuration(d.pollInterval, time.Until(deadline)))
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return nil, ctx.Err()
+		case <-d.notify:
+			wait.Stop()
+		case <-wait.C:
+		}
+	}
+}
+
+// Claim 认领租约空闲超过 minIdle（可见性超时）的消息
+func (d *DB) Claim(ctx context.Context, group, consumer string, priority Priority, minIdle time.Duration, count int) ([]Message, error) {
+	var out []Message
+	for len(out) < count {
+		msg, err := d.lease(ctx, consumer, "state = ? AND priority = ? AND leased_at < ?",
+			store.JobLeased, string(orNormal(priority)), time.Now().Add(-minIdle))
+		if err != nil || msg == nil {
+			return out, err
+		}
+		out = append(out, *msg)
+	}
+	return out, nil
+}
+
+// lease 在事务中抢占一条符合条件的消息并记录领取者，其他实例的并发领取会跳过被锁定的行
+func (d *DB) lease(ctx context.Context, consumer, query string, args ...any) (*Message, error) {
+	var msg *Message
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []store.TranscodeJob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(query, args...).Order("id").Limit(1).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		row := rows[0]
+		if err := tx.Model(&store.TranscodeJob{}).Where("id = ?", row.ID).Updates(map[string]any{
+			"state":       store.JobLeased,
+			"consumer":    consumer,
+			"leased_at":   time.Now(),
+			"retry_count": gorm.Expr("retry_count + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		msg = &Message{ID: strconv.FormatUint(uint64(row.ID), 10), Priority: Priority(row.Priority), Body: []byte(row.Payload)}
+		return nil
+	})
+	return msg, err
+}
+
+func (d *DB) Ack(ctx context.Context, group string, msg Message) error {
+	return d.db.WithContext(ctx).Where("id = ? AND state IN ?", msg.ID, []string{store.JobQueued, store.JobLeased}).
+		Delete(&store.TranscodeJob{}).Error
+}
+
+// DeadLetter 将消息置为 DEAD 并记录原因，保留在表中供人工排查
+func (d *DB) DeadLetter(ctx context.Context, group string, msg Message, reason string) error {
+	return d.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("id = ? AND state IN ?", msg.ID, []string{store.JobQueued, store.JobLeased}).
+		Updates(map[string]any{"state": store.JobDead, "error": reason}).Error
+}
+
+// Nack 放回 QUEUED 并按已投递次数退避，避免持续失败的作业占满 worker
+func (d *DB) Nack(ctx context.Context, group, consumer string, msg Message) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []store.TranscodeJob
+		if err := tx.Where("id = ? AND state = ? AND consumer = ?", msg.ID, store.JobLeased, consumer).
+			Limit(1).Find(&rows).Error; err != nil || len(rows) == 0 {
+			return err
+		}
+		return tx.Model(&store.TranscodeJob{}).Where("id = ?", msg.ID).Updates(map[string]any{
+			"state":        store.JobQueued,
+			"consumer":     "",
+			"available_at": time.Now().Add(retryDelay(rows[0].RetryCount)),
+		}).Error
+	})
+}
+
+func retryDelay(deliveries int) time.Duration {
+	delay := time.Duration(deliveries) * 10 * time.Second
+	if delay > 5*time.Minute {
+		delay = 5 * time.Minute
+	}
+	return delay
+}
+
+// Extend 刷新 leased_at。同一时刻内续约两次时值不变，MySQL 按实际变更行数返回 0，
+// 此时重新查询归属，避免被误判为租约已丢失
+func (d *DB) Extend(ctx context.Context, group, consumer string, msg Message) (bool, error) {
+	res := d.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("id = ? AND state = ? AND consumer = ?", msg.ID, store.JobLeased, consumer).
+		Update("leased_at", time.Now())
+	if res.Error != nil || res.RowsAffected == 1 {
+		return res.RowsAffected == 1, res.Error
+	}
+	var owned int64
+	err := d.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("id = ? AND state = ? AND consumer = ?", msg.ID, store.JobLeased, consumer).
+		Count(&owned).Error
+	return owned == 1, err
+}
+
+func (d *DB) Pending(ctx context.Context, group string, priority Priority, id string) (*PendingEntry, error) {
+	var rows []store.TranscodeJob
+	if err := d.db.WithContext(ctx).Where("id = ? AND state = ?", id, store.JobLeased).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	var idle time.Duration
+	if rows[0].LeasedAt != nil {
+		idle = time.Since(*rows[0].LeasedAt)
+	}
+	return &PendingEntry{Consumer: rows[0].Consumer, Idle: idle, RetryCount: int64(rows[0].RetryCount)}, nil
+}
+
+// Delivered 消息仍为 QUEUED 时返回 false；已领取或已 ACK 删除时返回 true
+func (d *DB) Delivered(ctx context.Context, group string, priority Priority, id string) (bool, error) {
+	var count int64
+	err := d.db.WithContext(ctx).Model(&store.TranscodeJob{}).
+		Where("id = ? AND state = ?", id, store.JobQueued).Count(&count).Error
+	return count == 0, err
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
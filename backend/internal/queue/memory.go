@@ -0,0 +1,247 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memory 进程内队列实现，供单节点部署与本地开发使用，进程重启后未完成的消息会丢失，
+// 由卡住作业巡检重新投递。消费组之间各自维护读取进度与 pending 列表。
+type Memory struct {
+	mu      sync.Mutex
+	notify  chan struct{}
+	lastMs  int64
+	seq     int64
+	streams map[Priority][]memoryEntry
+	groups  map[string]*memoryGroup
+	dead    []DeadMessage
+}
+
+// DeadMessage 进入死信队列的消息
+type DeadMessage struct {
+	Message
+	Reason string
+	At     time.Time
+}
+
+var (
+	_ Queue     = (*Memory)(nil)
+	_ Inspector = (*Memory)(nil)
+)
+
+type memoryEntry struct {
+	id   string
+	body []byte
+}
+
+type memoryGroup struct {
+	next    map[Priority]int // 下一条待领取消息在 streams 中的下标
+	pending map[string]*memoryPending
+}
+
+type memoryPending struct {
+	entry       memoryEntry
+	priority    Priority
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		notify:  make(chan struct{}),
+		streams: map[Priority][]memoryEntry{},
+		groups:  map[string]*memoryGroup{},
+	}
+}
+
+func (m *Memory) Setup(ctx context.Context, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group(group)
+	return nil
+}
+
+func (m *Memory) group(name string) *memoryGroup {
+	g, ok := m.groups[name]
+	if !ok {
+		g = &memoryGroup{next: map[Priority]int{}, pending: map[string]*memoryPending{}}
+		m.groups[name] = g
+	}
+	return g
+}
+
+// Enqueue 生成与 Redis Stream 相同格式的 ID（<ms>-<seq>），便于按 CompareIDs 比较
+func (m *Memory) Enqueue(ctx context.Context, payload JobPayload) (string, error) {
+	raw, err := Encode(payload)
+	if err != nil {
+		return "", err
+	}
+	p := orNormal(payload.Priority)
+	m.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= m.lastMs {
+		m.seq++
+	} else {
+		m.lastMs, m.seq = ms, 0
+	}
+	id := fmt.Sprintf("%d-%d", m.lastMs, m.seq)
+	m.streams[p] = append(m.streams[p], memoryEntry{id: id, body: raw})
+	close(m.notify)
+	m.notify = make(chan struct{})
+	m.mu.Unlock()
+	return id, nil
+}
+
+func (m *Memory) Consume(ctx context.Context, group, consumer string, priorities []Priority, block time.Duration) ([]Message, error) {
+	var deadline <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		m.mu.Lock()
+		g := m.group(group)
+		var out []Message
+		for _, p := range priorities {
+			i := g.next[p]
+			if i >= len(m.streams[p]) {
+				continue
+			}
+			entry := m.streams[p][i]
+			g.next[p] = i + 1
+			g.pending[entry.id] = &memoryPending{entry: entry, priority: p, consumer: consumer, deliveredAt: time.Now(), deliveries: 1}
+			out = append(out, Message{ID: entry.id, Priority: p, Body: entry.body})
+		}
+		notify := m.notify
+		m.mu.Unlock()
+		if len(out) > 0 || deadline == nil {
+			return out, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, nil
+		case <-notify:
+		}
+	}
+}
+
+func (m *Memory) Claim(ctx context.Context, group, consumer string, priority Priority, minIdle time.Duration, count int) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Message
+	for _, pe := range m.group(group).pending {
+		if len(out) >= count {
+			break
+		}
+		if pe.priority != orNormal(priority) || time.Since(pe.deliveredAt) < minIdle {
+			continue
+		}
+		pe.consumer = consumer
+		pe.deliveredAt = time.Now()
+		pe.deliveries++
+		out = append(out, Message{ID: pe.entry.id, Priority: priority, Body: pe.entry.body})
+	}
+	return out, nil
+}
+
+func (m *Memory) Ack(ctx context.Context, group string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.group(group)
+	delete(g.pending, msg.ID)
+	m.compact()
+	return nil
+}
+
+func (m *Memory) DeadLetter(ctx context.Context, group string, msg Message, reason string) error {
+	m.mu.Lock()
+	m.dead = append(m.dead, DeadMessage{Message: msg, Reason: reason, At: time.Now()})
+	m.mu.Unlock()
+	return m.Ack(ctx, group, msg)
+}
+
+// DeadLetters 返回死信队列中的消息
+func (m *Memory) DeadLetters() []DeadMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DeadMessage(nil), m.dead...)
+}
+
+func (m *Memory) Nack(ctx context.Context, group, consumer string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pe, ok := m.group(group).pending[msg.ID]; ok && pe.consumer == consumer {
+		pe.deliveredAt = time.Time{}
+	}
+	return nil
+}
+
+func (m *Memory) Extend(ctx context.Context, group, consumer string, msg Message) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pe, ok := m.group(group).pending[msg.ID]
+	if !ok || pe.consumer != consumer {
+		return false, nil
+	}
+	pe.deliveredAt = time.Now()
+	return true, nil
+}
+
+func (m *Memory) Pending(ctx context.Context, group string, priority Priority, id string) (*PendingEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pe, ok := m.group(group).pending[id]
+	if !ok || pe.priority != orNormal(priority) {
+		return nil, nil
+	}
+	return &PendingEntry{Consumer: pe.consumer, Idle: time.Since(pe.deliveredAt), RetryCount: pe.deliveries}, nil
+}
+
+// Delivered 进程重启后消息不再存在，同样视为已投递，交由巡检按无心跳处理
+func (m *Memory) Delivered(ctx context.Context, group string, priority Priority, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.group(group)
+	priority = orNormal(priority)
+	for _, e := range m.streams[priority][g.next[priority]:] {
+		if e.id == id {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compact 丢弃所有消费组都已领取的消息，避免内存无限增长
+func (m *Memory) compact() {
+	if len(m.groups) == 0 {
+		return
+	}
+	for _, p := range Priorities {
+		n := len(m.streams[p])
+		for _, g := range m.groups {
+			if g.next[p] < n {
+				n = g.next[p]
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		m.streams[p] = append([]memoryEntry(nil), m.streams[p][n:]...)
+		for _, g := range m.groups {
+			g.next[p] -= n
+		}
+	}
+}
+
+func orNormal(p Priority) Priority {
+	if p == "" {
+		return PriorityNormal
+	}
+	return p
+}
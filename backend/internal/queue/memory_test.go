@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const testGroup = "workers"
+
+func enqueue(t *testing.T, m *Memory, mediaID uint, p Priority) string {
+	t.Helper()
+	id, err := m.Enqueue(context.Background(), JobPayload{MediaID: mediaID, Source: "upload-x", Priority: p})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return id
+}
+
+func TestMemoryConsumeAndAck(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if err := m.Setup(ctx, testGroup); err != nil {
+		t.Fatal(err)
+	}
+	id := enqueue(t, m, 1, "")
+	msgs, err := m.Consume(ctx, testGroup, "c1", Priorities, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != id || msgs[0].Priority != PriorityNormal {
+		t.Fatalf("Consume = %+v", msgs)
+	}
+	payload, err := Decode(msgs[0].Body)
+	if err != nil || payload.MediaID != 1 {
+		t.Fatalf("Decode = %+v, %v", payload, err)
+	}
+	if delivered, _ := m.Delivered(ctx, testGroup, PriorityNormal, id); !delivered {
+		t.Error("领取后应视为已投递")
+	}
+	if pe, _ := m.Pending(ctx, testGroup, PriorityNormal, id); pe == nil || pe.Consumer != "c1" || pe.RetryCount != 1 {
+		t.Fatalf("Pending = %+v", pe)
+	}
+	if err := m.Ack(ctx, testGroup, msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pe, _ := m.Pending(ctx, testGroup, PriorityNormal, id); pe != nil {
+		t.Fatalf("ACK 后仍在 pending: %+v", pe)
+	}
+	if again, _ := m.Consume(ctx, testGroup, "c1", Priorities, 0); len(again) != 0 {
+		t.Fatalf("ACK 后不应再次领取: %+v", again)
+	}
+}
+
+func TestMemoryConsumeFollowsPriorityOrder(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	enqueue(t, m, 1, PriorityBulk)
+	enqueue(t, m, 2, PriorityHigh)
+	msgs, err := m.Consume(ctx, testGroup, "c1", []Priority{PriorityHigh, PriorityNormal, PriorityBulk}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Priority != PriorityHigh || msgs[1].Priority != PriorityBulk {
+		t.Fatalf("Consume = %+v", msgs)
+	}
+}
+
+func TestMemoryIDsIncrease(t *testing.T) {
+	m := NewMemory()
+	prev := enqueue(t, m, 1, "")
+	for i := 0; i < 5; i++ {
+		id := enqueue(t, m, 1, "")
+		if CompareIDs(id, prev) <= 0 {
+			t.Fatalf("ID 未递增: %s <= %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestMemoryConsumeBlocksUntilEnqueue(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	done := make(chan []Message, 1)
+	go func() {
+		msgs, _ := m.Consume(ctx, testGroup, "c1", Priorities, 5*time.Second)
+		done <- msgs
+	}()
+	time.Sleep(20 * time.Millisecond)
+	enqueue(t, m, 7, PriorityHigh)
+	select {
+	case msgs := <-done:
+		if len(msgs) != 1 {
+			t.Fatalf("Consume = %+v", msgs)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("入队后 Consume 未返回")
+	}
+}
+
+func TestMemoryConsumeTimesOut(t *testing.T) {
+	m := NewMemory()
+	msgs, err := m.Consume(context.Background(), testGroup, "c1", Priorities, 10*time.Millisecond)
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("Consume = %+v, %v", msgs, err)
+	}
+}
+
+func TestMemoryClaimRespectsIdleAndOwnership(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	enqueue(t, m, 1, "")
+	msgs, _ := m.Consume(ctx, testGroup, "c1", Priorities, 0)
+	msg := msgs[0]
+
+	if claimed, _ := m.Claim(ctx, testGroup, "c2", PriorityNormal, time.Hour, 10); len(claimed) != 0 {
+		t.Fatalf("未超过空闲时长不应被认领: %+v", claimed)
+	}
+	// Nack 让消息立即可被认领
+	if err := m.Nack(ctx, testGroup, "c1", msg); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ := m.Claim(ctx, testGroup, "c2", PriorityNormal, time.Hour, 10)
+	if len(claimed) != 1 || claimed[0].ID != msg.ID {
+		t.Fatalf("Claim = %+v", claimed)
+	}
+	if ok, _ := m.Extend(ctx, testGroup, "c1", msg); ok {
+		t.Error("被认领后原 consumer 续约应失败")
+	}
+	if ok, _ := m.Extend(ctx, testGroup, "c2", msg); !ok {
+		t.Error("新 consumer 续约应成功")
+	}
+	pe, _ := m.Pending(ctx, testGroup, PriorityNormal, msg.ID)
+	if pe == nil || pe.Consumer != "c2" || pe.RetryCount != 2 {
+		t.Fatalf("Pending = %+v", pe)
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	enqueue(t, m, 1, "")
+	msgs, _ := m.Consume(ctx, testGroup, "c1", Priorities, 0)
+	if err := m.DeadLetter(ctx, testGroup, msgs[0], "bad payload"); err != nil {
+		t.Fatal(err)
+	}
+	dead := m.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != msgs[0].ID || dead[0].Reason != "bad payload" {
+		t.Fatalf("DeadLetters = %+v", dead)
+	}
+	if pe, _ := m.Pending(ctx, testGroup, PriorityNormal, msgs[0].ID); pe != nil {
+		t.Fatalf("进入死信后仍在 pending: %+v", pe)
+	}
+}
+
+func TestMemoryGroupsAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_ = m.Setup(ctx, "a")
+	_ = m.Setup(ctx, "b")
+	id := enqueue(t, m, 1, "")
+	first, _ := m.Consume(ctx, "a", "c1", Priorities, 0)
+	if err := m.Ack(ctx, "a", first[0]); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, _ := m.Delivered(ctx, "b", PriorityNormal, id); delivered {
+		t.Error("组 b 尚未领取，不应视为已投递")
+	}
+	second, _ := m.Consume(ctx, "b", "c1", Priorities, 0)
+	if len(second) != 1 || second[0].ID != id {
+		t.Fatalf("组 b Consume = %+v", second)
+	}
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// PayloadVersion 当前写入的作业消息版本。
+// 新增字段只允许向后兼容地追加；需要改变既有字段含义时提升版本并在 Decode 中补充迁移
+const PayloadVersion = 2
+
+// 作业类型
+const (
+	JobTypeTranscode = "transcode"
+)
+
+// ErrUnknownJobType 消息类型不被当前版本识别，应转入死信队列而不是直接丢弃
+var ErrUnknownJobType = errors.New("unknown job type")
+
+type JobPayload struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	MediaID uint   `json:"mediaId"`
+	OwnerID string `json:"ownerId,omitempty"`
+	Source  string `json:"source"`
+	// IdempotencyKey 在写入 outbox 时生成，重复投递的同一作业 key 相同
+	IdempotencyKey string   `json:"idempotencyKey,omitempty"`
+	Priority       Priority `json:"priority,omitempty"`
+	// Profile 转码配置名，提交时即确定；为空的早期消息按默认配置执行
+	Profile string `json:"profile,omitempty"`
+	// Watermark 上传时按资源覆盖的水印参数（JSON），为空时使用转码配置中的水印
+	Watermark string `json:"watermark,omitempty"`
+}
+
+// payloadV1 未带 version/type 字段的早期消息
+type payloadV1 struct {
+	MediaID        uint     `json:"mediaId"`
+	OwnerID        string   `json:"ownerId,omitempty"`
+	Source         string   `json:"source"`
+	IdempotencyKey string   `json:"idempotencyKey,omitempty"`
+	Priority       Priority `json:"priority,omitempty"`
+}
+
+// Encode 补全版本与类型后序列化，所有写入队列与 outbox 的消息都应经过这里
+func Encode(payload JobPayload) ([]byte, error) {
+	payload.Version = PayloadVersion
+	if payload.Type == "" {
+		payload.Type = JobTypeTranscode
+	}
+	return json.Marshal(payload)
+}
+
+// Decode 解析任意版本的作业消息并迁移为当前结构。
+// 高于当前版本的消息按当前结构解析：字段只会追加，未知字段被忽略
+func Decode(body []byte) (JobPayload, error) {
+	var envelope struct {
+		Version int    `json:"version"`
+		Type    string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return JobPayload{}, fmt.Errorf("payload 解析失败: %w", err)
+	}
+	var payload JobPayload
+	switch {
+	case envelope.Version <= 1:
+		var v1 payloadV1
+		if err := json.Unmarshal(body, &v1); err != nil {
+			return JobPayload{}, fmt.Errorf("payload v1 解析失败: %w", err)
+		}
+		payload = JobPayload{
+			Type:           JobTypeTranscode,
+			MediaID:        v1.MediaID,
+			OwnerID:        v1.OwnerID,
+			Source:         legacySourceKey(v1.Source),
+			IdempotencyKey: v1.IdempotencyKey,
+			Priority:       v1.Priority,
+		}
+	default:
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return JobPayload{}, fmt.Errorf("payload v%d 解析失败: %w", envelope.Version, err)
+		}
+	}
+	if payload.Type != JobTypeTranscode {
+		return JobPayload{}, fmt.Errorf("%w: %q", ErrUnknownJobType, payload.Type)
+	}
+	payload.Version = PayloadVersion
+	return payload, nil
+}
+
+// legacySourceKey 早期消息的 source 是 UPLOAD_DIR 下的文件路径（如 data/uploads/upload-…），
+// 上传存储的 key 是不含目录的文件名，取 base name 即可；已经是 key 的值保持不变
+func legacySourceKey(source string) string {
+	if source == "" {
+		return ""
+	}
+	return filepath.Base(filepath.ToSlash(source))
+}
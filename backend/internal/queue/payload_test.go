@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeBaselinePayload(t *testing.T) {
+	// 早期 Dispatcher.EnqueueJob 写入的消息：只有 mediaId 与 UPLOAD_DIR 下的文件路径
+	body := []byte(`{"mediaId":42,"source":"data/uploads/upload-1700000000000000000-my-clip.mp4"}`)
+	got, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := JobPayload{
+		Version: PayloadVersion,
+		Type:    JobTypeTranscode,
+		MediaID: 42,
+		Source:  "upload-1700000000000000000-my-clip.mp4",
+	}
+	if got != want {
+		t.Fatalf("Decode = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeV1Source(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"relative upload path", "data/uploads/upload-1-a.mp4", "upload-1-a.mp4"},
+		{"dot-relative upload path", "./data/uploads/upload-1-a.mp4", "upload-1-a.mp4"},
+		{"absolute remote path", "/app/data/uploads/remote-7-1700000000000000000.mp4", "remote-7-1700000000000000000.mp4"},
+		{"already a key", "upload-1-a.mp4", "upload-1-a.mp4"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(`{"version":1,"mediaId":1,"source":"` + tt.source + `","priority":"high"}`)
+			got, err := Decode(body)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if got.Source != tt.want {
+				t.Errorf("Source = %q, want %q", got.Source, tt.want)
+			}
+			if got.Priority != PriorityHigh {
+				t.Errorf("Priority = %q, want %q", got.Priority, PriorityHigh)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	in := JobPayload{
+		MediaID:        3,
+		OwnerID:        "u1",
+		Source:         "upload-3-b.mov",
+		IdempotencyKey: "k",
+		Priority:       PriorityBulk,
+		Profile:        "h264",
+		Watermark:      `{"opacity":0.5}`,
+	}
+	raw, err := Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(raw)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	in.Version, in.Type = PayloadVersion, JobTypeTranscode
+	if got != in {
+		t.Fatalf("round trip = %+v, want %+v", got, in)
+	}
+}
+
+func TestDecodeNewerVersionIgnoresUnknownFields(t *testing.T) {
+	body := []byte(`{"version":99,"type":"transcode","mediaId":5,"source":"upload-5","future":{"x":1}}`)
+	got, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.MediaID != 5 || got.Source != "upload-5" || got.Version != PayloadVersion {
+		t.Fatalf("Decode = %+v", got)
+	}
+}
+
+func TestDecodeUnknownType(t *testing.T) {
+	_, err := Decode([]byte(`{"version":2,"type":"thumbnail","mediaId":1}`))
+	if !errors.Is(err, ErrUnknownJobType) {
+		t.Fatalf("err = %v, want ErrUnknownJobType", err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	if _, err := Decode([]byte(`not json`)); err == nil {
+		t.Fatal("expected error for malformed payload")
+	}
+}
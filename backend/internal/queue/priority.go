@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority 作业优先级，每个优先级对应一条独立的 Stream
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityBulk   Priority = "bulk"
+)
+
+// Priorities 按从高到低排列
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityBulk}
+
+// ParsePriority 解析优先级，空串视为 normal
+func ParsePriority(s string) (Priority, error) {
+	switch p := Priority(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return PriorityNormal, nil
+	case PriorityHigh, PriorityNormal, PriorityBulk:
+		return p, nil
+	}
+	return "", fmt.Errorf("未知优先级: %s", s)
+}
+
+// Rank 数值越小优先级越高
+func (p Priority) Rank() int {
+	for i, q := range Priorities {
+		if q == p {
+			return i
+		}
+	}
+	return 1
+}
+
+// Shift 按 Rank 偏移 delta 级，超出范围时取边界
+func (p Priority) Shift(delta int) Priority {
+	i := p.Rank() + delta
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(Priorities) {
+		i = len(Priorities) - 1
+	}
+	return Priorities[i]
+}
+
+// ParseWeights 解析 "high=6,normal=3,bulk=1" 形式的读取权重，未列出的优先级权重为 1
+func ParseWeights(s string) (map[Priority]int, error) {
+	weights := map[Priority]int{}
+	for _, p := range Priorities {
+		weights[p] = 1
+	}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("权重格式错误: %s", part)
+		}
+		p, err := ParsePriority(name)
+		if err != nil {
+			return nil, err
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("权重必须为正整数: %s", part)
+		}
+		weights[p] = w
+	}
+	return weights, nil
+}
+
+// Picker 平滑加权轮询：权重 6:3:1 时每 10 次读取中 high 轮到 6 次，
+// 且各优先级交错出现，低优先级不会被饿死
+type Picker struct {
+	weights map[Priority]int
+	current map[Priority]int
+}
+
+func NewPicker(weights map[Priority]int) *Picker {
+	return &Picker{weights: weights, current: map[Priority]int{}}
+}
+
+// Order 返回本轮的读取顺序：轮到的优先级在前，其余按优先级从高到低
+func (p *Picker) Order() []Priority {
+	total := 0
+	var best Priority
+	for _, q := range Priorities {
+		w := p.weights[q]
+		total += w
+		p.current[q] += w
+		if best == "" || p.current[q] > p.current[best] {
+			best = q
+		}
+	}
+	p.current[best] -= total
+	order := []Priority{best}
+	for _, q := range Priorities {
+		if q != best {
+			order = append(order, q)
+		}
+	}
+	return order
+}
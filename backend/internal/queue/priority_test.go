@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[Priority]int
+		wantErr bool
+	}{
+		{in: "", want: map[Priority]int{PriorityHigh: 1, PriorityNormal: 1, PriorityBulk: 1}},
+		{in: "high=6,normal=3,bulk=1", want: map[Priority]int{PriorityHigh: 6, PriorityNormal: 3, PriorityBulk: 1}},
+		{in: " HIGH = 4 , ,bulk=2", want: map[Priority]int{PriorityHigh: 4, PriorityNormal: 1, PriorityBulk: 2}},
+		{in: "high", wantErr: true},
+		{in: "urgent=3", wantErr: true},
+		{in: "high=0", wantErr: true},
+		{in: "high=-1", wantErr: true},
+		{in: "high=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWeights(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseWeights(%q) 应返回错误", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWeights(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWeights(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPickerFollowsWeights(t *testing.T) {
+	p := NewPicker(map[Priority]int{PriorityHigh: 6, PriorityNormal: 3, PriorityBulk: 1})
+	counts := map[Priority]int{}
+	for i := 0; i < 100; i++ {
+		order := p.Order()
+		if len(order) != len(Priorities) {
+			t.Fatalf("Order() = %v，应包含全部优先级", order)
+		}
+		counts[order[0]]++
+	}
+	want := map[Priority]int{PriorityHigh: 60, PriorityNormal: 30, PriorityBulk: 10}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("100 轮中首选次数 = %v, want %v", counts, want)
+	}
+}
+
+func TestPickerInterleaves(t *testing.T) {
+	p := NewPicker(map[Priority]int{PriorityHigh: 2, PriorityNormal: 1, PriorityBulk: 1})
+	var got []Priority
+	for i := 0; i < 4; i++ {
+		got = append(got, p.Order()[0])
+	}
+	want := []Priority{PriorityHigh, PriorityNormal, PriorityBulk, PriorityHigh}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("首选顺序 = %v, want %v", got, want)
+	}
+}
+
+func TestPriorityShift(t *testing.T) {
+	tests := []struct {
+		p     Priority
+		delta int
+		want  Priority
+	}{
+		{PriorityNormal, -1, PriorityHigh},
+		{PriorityNormal, 1, PriorityBulk},
+		{PriorityHigh, -1, PriorityHigh},
+		{PriorityBulk, 2, PriorityBulk},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Shift(tt.delta); got != tt.want {
+			t.Errorf("%s.Shift(%d) = %s, want %s", tt.p, tt.delta, got, tt.want)
+		}
+	}
+}
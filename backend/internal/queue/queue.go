@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Message 从队列领取的一条消息
+type Message struct {
+	ID       string
+	Priority Priority // 消息所在的优先级队列
+	Body     []byte
+}
+
+// Queue 作业队列：消息领取后归属领取的 consumer，直到 ACK；
+// 空闲超过租约时长的消息可被其他 consumer 认领
+type Queue interface {
+	// Setup 创建消费组等准备工作，可重复调用
+	Setup(ctx context.Context, group string) error
+	// Enqueue 按作业优先级写入，返回消息 ID
+	Enqueue(ctx context.Context, payload JobPayload) (string, error)
+	// Consume 从 priorities 对应的队列中领取新消息，每个队列至多一条；
+	// 全部为空时最多等待 block，block 为负数时不等待
+	Consume(ctx context.Context, group, consumer string, priorities []Priority, block time.Duration) ([]Message, error)
+	// Claim 认领 priority 队列中空闲超过 minIdle 的已领取消息
+	Claim(ctx context.Context, group, consumer string, priority Priority, minIdle time.Duration, count int) ([]Message, error)
+	Ack(ctx context.Context, group string, msg Message) error
+	// Nack 放弃消息，使其可被立即重新认领
+	Nack(ctx context.Context, group, consumer string, msg Message) error
+	// Extend 续约，返回 false 表示消息已不归属 consumer
+	Extend(ctx context.Context, group, consumer string, msg Message) (bool, error)
+	// DeadLetter 将无法处理的消息连同原因转入死信队列并确认原消息
+	DeadLetter(ctx context.Context, group string, msg Message, reason string) error
+}
+
+// PendingEntry 已领取未 ACK 的消息状态
+type PendingEntry struct {
+	Consumer   string
+	Idle       time.Duration
+	RetryCount int64
+}
+
+// Inspector 可选能力：按消息 ID 查询投递状态，供卡住作业巡检使用
+type Inspector interface {
+	// Pending 消息已领取但未 ACK 时返回其状态，否则返回 nil
+	Pending(ctx context.Context, group string, priority Priority, id string) (*PendingEntry, error)
+	// Delivered 消息已不在待领取队列中时返回 true
+	Delivered(ctx context.Context, group string, priority Priority, id string) (bool, error)
+}
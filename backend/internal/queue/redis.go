@@ -2,21 +2,25 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Dispatcher 基于 Redis Streams 的队列实现。每个优先级写入一条 Stream：
+// normal 沿用 QUEUE_STREAM 本身以兼容存量消息，其余为 "<stream>:<priority>"
 type Dispatcher struct {
 	client *redis.Client
 	stream string
+	maxLen int64
 }
 
-type JobPayload struct {
-	MediaID uint   `json:"mediaId"`
-	Source  string `json:"source"`
-}
+var (
+	_ Queue     = (*Dispatcher)(nil)
+	_ Inspector = (*Dispatcher)(nil)
+)
 
 func NewRedis(url string) *redis.Client {
 	opt, err := redis.ParseURL(url)
@@ -26,37 +30,182 @@ func NewRedis(url string) *redis.Client {
 	return redis.NewClient(opt)
 }
 
-func NewDispatcher(client *redis.Client, stream string) *Dispatcher {
-	return &Dispatcher{client: client, stream: stream}
+// NewDispatcher maxLen > 0 时 XADD 附带 MAXLEN ~ maxLen 作为 Stream 长度的硬上限。
+// 超出上限时未消费的消息同样会被裁掉，应设置为远大于正常积压量的值
+func NewDispatcher(client *redis.Client, stream string, maxLen int64) *Dispatcher {
+	return &Dispatcher{client: client, stream: stream, maxLen: maxLen}
 }
 
 func (d *Dispatcher) Client() *redis.Client {
 	return d.client
 }
 
-func (d *Dispatcher) Stream() string {
-	return d.stream
+// StreamFor 返回优先级对应的 Stream
+func (d *Dispatcher) StreamFor(p Priority) string {
+	if p == "" || p == PriorityNormal {
+		return d.stream
+	}
+	return d.stream + ":" + string(p)
 }
 
-func (d *Dispatcher) Enqueue(ctx context.Context, payload map[string]any) error {
-	return d.client.XAdd(ctx, &redis.XAddArgs{Stream: d.stream, ID: "*", Values: payload}).Err()
+func (d *Dispatcher) Setup(ctx context.Context, group string) error {
+	for _, p := range Priorities {
+		if err := d.client.XGroupCreateMkStream(ctx, d.StreamFor(p), group, "0").Err(); err != nil {
+			if !strings.Contains(err.Error(), "BUSYGROUP") {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (d *Dispatcher) EnqueueJob(ctx context.Context, payload JobPayload) error {
-	raw, err := json.Marshal(payload)
+// Enqueue 按作业优先级写入对应 Stream
+func (d *Dispatcher) Enqueue(ctx context.Context, payload JobPayload) (string, error) {
+	raw, err := Encode(payload)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return d.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.StreamFor(payload.Priority),
+		MaxLen: d.maxLen,
+		Approx: d.maxLen > 0,
+		ID:     "*",
+		Values: map[string]any{"payload": string(raw)},
+	}).Result()
+}
+
+// Consume 每个 Stream 只领取一条：消息一旦读出即开始计算租约空闲时间，
+// 多读的消息在排队期间可能被其他实例认领，导致重复转码
+func (d *Dispatcher) Consume(ctx context.Context, group, consumer string, priorities []Priority, block time.Duration) ([]Message, error) {
+	args := make([]string, 0, len(priorities)*2)
+	byStream := map[string]Priority{}
+	for _, p := range priorities {
+		args = append(args, d.StreamFor(p))
+		byStream[d.StreamFor(p)] = p
+	}
+	for range priorities {
+		args = append(args, ">")
+	}
+	res, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  args,
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Message
+	for _, s := range res {
+		for _, m := range s.Messages {
+			out = append(out, toMessage(byStream[s.Stream], m))
+		}
 	}
-	return d.Enqueue(ctx, map[string]any{"payload": string(raw)})
+	return out, nil
 }
 
-func (d *Dispatcher) Consume(ctx context.Context, group, consumer string) ([]redis.XMessage, error) {
-	msgs, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+func (d *Dispatcher) Claim(ctx context.Context, group, consumer string, priority Priority, minIdle time.Duration, count int) ([]Message, error) {
+	msgs, _, err := d.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   d.StreamFor(priority),
 		Group:    group,
 		Consumer: consumer,
-		Streams:  []string{d.stream, ">"},
-		Count:    10,
-		Block:    5 * time.Second,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    int64(count),
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, toMessage(priority, m))
+	}
+	return out, nil
+}
+
+// toMessage 取出 payload 字段，字段缺失或类型不符时 Body 为空，由调用方按无效消息处理
+func toMessage(p Priority, m redis.XMessage) Message {
+	msg := Message{ID: m.ID, Priority: p}
+	switch v := m.Values["payload"].(type) {
+	case string:
+		msg.Body = []byte(v)
+	case []byte:
+		msg.Body = v
+	}
+	return msg
+}
+
+func (d *Dispatcher) Ack(ctx context.Context, group string, msg Message) error {
+	return d.client.XAck(ctx, d.StreamFor(msg.Priority), group, msg.ID).Err()
+}
+
+// Nack 保留消息在 PEL 中，但把空闲时间设为极大值，下一次 XAUTOCLAIM 即可认领。
+// 不重新 XADD，使 outbox 记录的消息 ID 保持有效
+func (d *Dispatcher) Nack(ctx context.Context, group, consumer string, msg Message) error {
+	idle := (365 * 24 * time.Hour).Milliseconds()
+	return d.client.Do(ctx, "XCLAIM", d.StreamFor(msg.Priority), group, consumer, 0, msg.ID,
+		"IDLE", idle, "JUSTID").Err()
+}
+
+// DeadLetterStream 死信 Stream，保存无法解析或类型未知的消息供人工排查
+func (d *Dispatcher) DeadLetterStream() string {
+	return d.stream + ":dead"
+}
+
+func (d *Dispatcher) DeadLetter(ctx context.Context, group string, msg Message, reason string) error {
+	if err := d.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.DeadLetterStream(),
+		MaxLen: d.maxLen,
+		Approx: d.maxLen > 0,
+		ID:     "*",
+		Values: map[string]any{
+			"payload":   string(msg.Body),
+			"reason":    reason,
+			"stream":    d.StreamFor(msg.Priority),
+			"messageId": msg.ID,
+		},
+	}).Err(); err != nil {
+		return err
+	}
+	return d.Ack(ctx, group, msg)
+}
+
+// extendScript 在同一个脚本内确认消息仍在 consumer 的 PEL 中再以 XCLAIM JUSTID 重置空闲时间，
+// 避免查询与认领之间消息被其他实例的 XAUTOCLAIM 抢走后又被这里夺回
+var extendScript = redis.NewScript(`
+local entries = redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[3], ARGV[3], 1, ARGV[2])
+if #entries == 0 then
+	return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[1], ARGV[2], 0, ARGV[3], 'JUSTID')
+return 1
+`)
+
+// Extend 续约：消息仍归属 consumer 时重置其空闲时间，
+// 使其他实例的 XAUTOCLAIM 不会认领仍在执行的作业
+func (d *Dispatcher) Extend(ctx context.Context, group, consumer string, msg Message) (bool, error) {
+	owned, err := extendScript.Run(ctx, d.client, []string{d.StreamFor(msg.Priority)}, group, consumer, msg.ID).Int()
+	if err != nil {
+		return false, err
+	}
+	return owned == 1, nil
+}
+
+// Pending 查询单条消息是否仍在 PEL 中（已投递但未 ACK）
+func (d *Dispatcher) Pending(ctx context.Context, group string, priority Priority, id string) (*PendingEntry, error) {
+	entries, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: d.StreamFor(priority),
+		Group:  group,
+		Start:  id,
+		End:    id,
+		Count:  1,
 	}).Result()
 	if err != nil {
 		if err == redis.Nil {
@@ -64,22 +213,48 @@ func (d *Dispatcher) Consume(ctx context.Context, group, consumer string) ([]red
 		}
 		return nil, err
 	}
-	if len(msgs) == 0 {
+	if len(entries) == 0 {
 		return nil, nil
 	}
-	return msgs[0].Messages, nil
+	return &PendingEntry{Consumer: entries[0].Consumer, Idle: entries[0].Idle, RetryCount: entries[0].RetryCount}, nil
 }
 
-func (d *Dispatcher) Ack(ctx context.Context, group, id string) error {
-	return d.client.XAck(ctx, d.stream, group, id).Err()
+// Delivered 判断消息是否已投递给消费组（ID 不大于组的 last-delivered-id）
+func (d *Dispatcher) Delivered(ctx context.Context, group string, priority Priority, id string) (bool, error) {
+	groups, err := d.client.XInfoGroups(ctx, d.StreamFor(priority)).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range groups {
+		if g.Name == group {
+			return CompareIDs(id, g.LastDeliveredID) <= 0, nil
+		}
+	}
+	return false, nil
 }
 
-func (d *Dispatcher) Retry(ctx context.Context, group, id string) error {
-	return d.client.XClaim(ctx, &redis.XClaimArgs{
-		Stream:   d.stream,
-		Group:    group,
-		Consumer: group + "-retry",
-		MinIdle:  30 * time.Second,
-		Messages: []string{id},
-	}).Err()
+// CompareIDs 比较两个 Stream 消息 ID（<ms>-<seq>）
+func CompareIDs(a, b string) int {
+	am, as := splitID(a)
+	bm, bs := splitID(b)
+	switch {
+	case am != bm:
+		if am < bm {
+			return -1
+		}
+		return 1
+	case as != bs:
+		if as < bs {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func splitID(id string) (uint64, uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
 }
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// streamLength 各 Stream 的当前长度，通过 /debug/vars 暴露
+var streamLength = expvar.NewMap("queue_stream_length")
+
+// Trimmer 周期性裁剪已确认的历史消息：保留 retention 时长内的消息供审计，
+// 但永远不会裁掉任何消费组尚未 ACK 或尚未领取的消息
+type Trimmer struct {
+	dispatcher *Dispatcher
+	retention  time.Duration
+	logger     *log.Logger
+}
+
+func NewTrimmer(dispatcher *Dispatcher, retention time.Duration, logger *log.Logger) *Trimmer {
+	return &Trimmer{dispatcher: dispatcher, retention: retention, logger: logger}
+}
+
+func (t *Trimmer) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := t.RunOnce(ctx); err != nil {
+				t.logger.Printf("trim stream error: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (t *Trimmer) RunOnce(ctx context.Context) error {
+	client := t.dispatcher.Client()
+	for _, p := range Priorities {
+		stream := t.dispatcher.StreamFor(p)
+		minID, err := t.minID(ctx, stream)
+		if err != nil {
+			return err
+		}
+		if minID != "" {
+			trimmed, err := client.XTrimMinIDApprox(ctx, stream, minID, 0).Result()
+			if err != nil {
+				return err
+			}
+			if trimmed > 0 {
+				t.logger.Printf("trimmed %d entries from %s before %s", trimmed, stream, minID)
+			}
+		}
+		n, err := client.XLen(ctx, stream).Result()
+		if err != nil {
+			return err
+		}
+		streamLength.Set(stream, expvarInt(n))
+	}
+	dead, err := client.XLen(ctx, t.dispatcher.DeadLetterStream()).Result()
+	if err != nil {
+		return err
+	}
+	streamLength.Set(t.dispatcher.DeadLetterStream(), expvarInt(dead))
+	return nil
+}
+
+// minID 取保留期截止点、各消费组最早的 pending 消息与 last-delivered-id 中的最小值，
+// 返回空串表示无需裁剪
+func (t *Trimmer) minID(ctx context.Context, stream string) (string, error) {
+	if t.retention <= 0 {
+		return "", nil
+	}
+	client := t.dispatcher.Client()
+	minID := fmt.Sprintf("%d-0", time.Now().Add(-t.retention).UnixMilli())
+	groups, err := client.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		if err == redis.Nil || isNoSuchKey(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, g := range groups {
+		if CompareIDs(g.LastDeliveredID, minID) < 0 {
+			minID = g.LastDeliveredID
+		}
+		pending, err := client.XPending(ctx, stream, g.Name).Result()
+		if err != nil {
+			return "", err
+		}
+		if pending.Count > 0 && CompareIDs(pending.Lower, minID) < 0 {
+			minID = pending.Lower
+		}
+	}
+	return minID, nil
+}
+
+func isNoSuchKey(err error) bool {
+	return err != nil && err.Error() == "ERR no such key"
+}
+
+func expvarInt(n int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(n)
+	return v
+}
@@ -0,0 +1,177 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"parallel/internal/queue"
+	"parallel/internal/store"
+	"parallel/pkg/api"
+	"parallel/pkg/auth"
+)
+
+var (
+	ErrStorageExceeded   = errors.New("存储配额已用尽")
+	ErrTranscodeExceeded = errors.New("转码时长配额已用尽")
+)
+
+// Limits 每个 OwnerID 的配额，0 表示不限制
+type Limits struct {
+	MaxBytes            int64
+	MaxTranscodeSeconds float64
+}
+
+type Usage struct {
+	OwnerID          string  `json:"ownerId"`
+	BytesStored      int64   `json:"bytesStored"`
+	TranscodeSeconds float64 `json:"transcodeSeconds"`
+	MaxBytes         int64   `json:"maxBytes"`
+	MaxTranscodeSecs float64 `json:"maxTranscodeSeconds"`
+}
+
+// Service 统计并校验每个 OwnerID 的存储字节数与转码耗时
+type Service struct {
+	db     *gorm.DB
+	limits Limits
+}
+
+func NewService(db *gorm.DB, limits Limits) *Service {
+	return &Service{db: db, limits: limits}
+}
+
+func (s *Service) Usage(ctx context.Context, ownerID string) (Usage, error) {
+	var row store.OwnerUsage
+	err := s.db.WithContext(ctx).Where("owner_id = ?", ownerID).Take(&row).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Usage{}, err
+	}
+	return Usage{
+		OwnerID:          ownerID,
+		BytesStored:      row.BytesStored,
+		TranscodeSeconds: row.TranscodeSeconds,
+		MaxBytes:         s.limits.MaxBytes,
+		MaxTranscodeSecs: s.limits.MaxTranscodeSeconds,
+	}, nil
+}
+
+// RemainingBytes 返回剩余可用存储；limited=false 表示不限制
+func (s *Service) RemainingBytes(ctx context.Context, ownerID string) (remaining int64, limited bool, err error) {
+	if s.limits.MaxBytes <= 0 {
+		return 0, false, nil
+	}
+	u, err := s.Usage(ctx, ownerID)
+	if err != nil {
+		return 0, true, err
+	}
+	remaining = s.limits.MaxBytes - u.BytesStored
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true, nil
+}
+
+// CheckStorage 校验再写入 incoming 字节后是否超出存储配额
+func (s *Service) CheckStorage(ctx context.Context, ownerID string, incoming int64) error {
+	remaining, limited, err := s.RemainingBytes(ctx, ownerID)
+	if err != nil || !limited {
+		return err
+	}
+	if remaining <= 0 || incoming > remaining {
+		return ErrStorageExceeded
+	}
+	return nil
+}
+
+// ReserveBytes 在配额内原子地占用 n 字节：以带条件的 UPDATE 同时完成校验与累加，
+// 并发上传不会一起通过校验后超出配额。写入失败时调用方以 AddBytes(-n) 归还
+func (s *Service) ReserveBytes(ctx context.Context, ownerID string, n int64) error {
+	if s.limits.MaxBytes <= 0 {
+		return s.AddBytes(ctx, ownerID, n)
+	}
+	if ownerID == "" || n <= 0 {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&store.OwnerUsage{OwnerID: ownerID}).Error; err != nil {
+		return err
+	}
+	res := s.db.WithContext(ctx).Model(&store.OwnerUsage{}).
+		Where("owner_id = ? AND bytes_stored + ? <= ?", ownerID, n, s.limits.MaxBytes).
+		Updates(map[string]any{"bytes_stored": gorm.Expr("bytes_stored + ?", n), "updated_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStorageExceeded
+	}
+	return nil
+}
+
+// CheckTranscode 校验转码耗时是否已用尽
+func (s *Service) CheckTranscode(ctx context.Context, ownerID string) error {
+	if s.limits.MaxTranscodeSeconds <= 0 {
+		return nil
+	}
+	u, err := s.Usage(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if u.TranscodeSeconds >= s.limits.MaxTranscodeSeconds {
+		return ErrTranscodeExceeded
+	}
+	return nil
+}
+
+// AddBytes 累加存储用量，delta 可为负（删除文件时）
+func (s *Service) AddBytes(ctx context.Context, ownerID string, delta int64) error {
+	if ownerID == "" || delta == 0 {
+		return nil
+	}
+	return s.upsert(ctx, store.OwnerUsage{OwnerID: ownerID, BytesStored: max(delta, 0)},
+		map[string]any{"bytes_stored": gorm.Expr("GREATEST(bytes_stored + ?, 0)", delta)})
+}
+
+// AddTranscodeSeconds 累加转码耗时
+func (s *Service) AddTranscodeSeconds(ctx context.Context, ownerID string, seconds float64) error {
+	if ownerID == "" || seconds <= 0 {
+		return nil
+	}
+	return s.upsert(ctx, store.OwnerUsage{OwnerID: ownerID, TranscodeSeconds: seconds},
+		map[string]any{"transcode_seconds": gorm.Expr("transcode_seconds + ?", seconds)})
+}
+
+func (s *Service) upsert(ctx context.Context, row store.OwnerUsage, updates map[string]any) error {
+	updates["updated_at"] = time.Now()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "owner_id"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(&row).Error
+}
+
+// Admit 供调度器在执行作业前校验转码时长配额；查询失败时放行，避免数据库抖动导致作业被误判失败
+func (s *Service) Admit(ctx context.Context, payload queue.JobPayload) error {
+	if payload.OwnerID == "" {
+		return nil
+	}
+	if err := s.CheckTranscode(ctx, payload.OwnerID); errors.Is(err, ErrTranscodeExceeded) {
+		return err
+	}
+	return nil
+}
+
+// HandleUsage GET /api/v1/usage
+func (s *Service) HandleUsage(c *gin.Context) {
+	usage, err := s.Usage(c.Request.Context(), auth.OwnerID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.Error("查询用量失败"))
+		return
+	}
+	status, body := api.Ok(usage)
+	c.JSON(status, body)
+}
@@ -0,0 +1,227 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"parallel/internal/media"
+	"parallel/internal/queue"
+	"parallel/internal/store"
+)
+
+// 巡检结论
+const (
+	DecisionRequeue     = "requeue"
+	DecisionFail        = "fail"
+	DecisionWaitOutbox  = "wait_outbox"
+	DecisionWaitQueued  = "wait_queued"
+	DecisionWaitPending = "wait_pending"
+	DecisionWaitFetch   = "wait_fetch"
+)
+
+type Options struct {
+	Interval time.Duration
+	// StaleAfter 作业心跳超过该时长视为 worker 已失联
+	StaleAfter time.Duration
+	// FetchTimeout 远程拉取（尚无源文件）允许的最长时间
+	FetchTimeout time.Duration
+	// MaxRequeues 巡检最多为同一资源重新投递的次数，超过后标记失败
+	MaxRequeues int
+	// MaxDeliveries 消息在 PEL 中被投递超过该次数视为毒消息
+	MaxDeliveries int64
+}
+
+// Notifier 重新写入 outbox 后唤醒 relay
+type Notifier interface {
+	Notify()
+}
+
+// Reconciler 周期性检查停留在 PROCESSING 的资源：结合作业心跳、outbox 与队列投递状态
+// 判断作业是否仍在推进，否则重新投递或标记失败，并记录每次处置。
+type Reconciler struct {
+	repo   *media.Repository
+	db     *gorm.DB
+	queue  queue.Queue
+	group  string
+	relay  Notifier
+	opts   Options
+	logger *log.Logger
+}
+
+func New(repo *media.Repository, db *gorm.DB, q queue.Queue, group string, relay Notifier, opts Options, logger *log.Logger) *Reconciler {
+	return &Reconciler{repo: repo, db: db, queue: q, group: group, relay: relay, opts: opts, logger: logger}
+}
+
+func (r *Reconciler) Start(ctx context.Context) {
+	if r.opts.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.RunOnce(ctx); err != nil {
+					r.logger.Printf("reconcile error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *Reconciler) RunOnce(ctx context.Context) error {
+	assets, err := r.repo.ListProcessingBefore(ctx, time.Now().Add(-r.opts.StaleAfter), 200)
+	if err != nil {
+		return err
+	}
+	for i := range assets {
+		if err := r.reconcile(ctx, &assets[i]); err != nil {
+			r.logger.Printf("reconcile media %d error: %v", assets[i].ID, err)
+		}
+	}
+	return nil
+}
+
+type decision struct {
+	kind      string
+	reason    string
+	jobID     uint
+	messageID string
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, asset *store.MediaAsset) error {
+	job, err := r.repo.LatestJob(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	if job != nil && job.State == store.JobRunning && job.HeartbeatAt != nil &&
+		time.Since(*job.HeartbeatAt) < r.opts.StaleAfter {
+		return nil
+	}
+	d := decision{}
+	if job != nil {
+		d.jobID = job.ID
+	}
+
+	ob, err := r.repo.LatestOutbox(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	if ob == nil {
+		if asset.SourceKey == "" && time.Since(asset.CreatedAt) < r.opts.FetchTimeout {
+			d.kind, d.reason = DecisionWaitFetch, "远程拉取进行中"
+			return r.apply(ctx, asset, nil, d)
+		}
+		return r.requeueOrFail(ctx, asset, nil, d, "没有待投递的作业")
+	}
+	d.messageID = ob.MessageID
+	if ob.Status == store.OutboxDead {
+		d.kind, d.reason = DecisionFail, fmt.Sprintf("outbox 记录无法投递: %s", ob.LastError)
+		return r.apply(ctx, asset, ob, d)
+	}
+	if ob.Status == store.OutboxPending {
+		d.kind, d.reason = DecisionWaitOutbox, fmt.Sprintf("outbox 待投递，已尝试 %d 次", ob.Attempts)
+		return r.apply(ctx, asset, ob, d)
+	}
+
+	inspector, ok := r.queue.(queue.Inspector)
+	if !ok {
+		// 队列不支持查询投递状态时只能依据作业心跳判断
+		return r.requeueOrFail(ctx, asset, ob, d, "作业无心跳")
+	}
+	// 消息按作业优先级写入不同的队列
+	msg := queue.Message{ID: ob.MessageID, Priority: jobPayload(asset, ob).Priority}
+	pending, err := inspector.Pending(ctx, r.group, msg.Priority, msg.ID)
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		if r.opts.MaxDeliveries > 0 && pending.RetryCount >= r.opts.MaxDeliveries {
+			// 先 ACK，避免调度器继续认领这条毒消息
+			if err := r.queue.Ack(ctx, r.group, msg); err != nil {
+				return err
+			}
+			d.kind, d.reason = DecisionFail, fmt.Sprintf("消息已投递 %d 次仍未完成", pending.RetryCount)
+			return r.apply(ctx, asset, ob, d)
+		}
+		d.kind, d.reason = DecisionWaitPending, fmt.Sprintf("消息在 %s 的 PEL 中，空闲 %s，等待认领", pending.Consumer, pending.Idle.Truncate(time.Second))
+		return r.apply(ctx, asset, ob, d)
+	}
+	delivered, err := inspector.Delivered(ctx, r.group, msg.Priority, msg.ID)
+	if err != nil {
+		return err
+	}
+	if !delivered {
+		d.kind, d.reason = DecisionWaitQueued, "消息尚未被消费"
+		return r.apply(ctx, asset, ob, d)
+	}
+	return r.requeueOrFail(ctx, asset, ob, d, "消息已确认但资源仍未完成，且作业无心跳")
+}
+
+func (r *Reconciler) requeueOrFail(ctx context.Context, asset *store.MediaAsset, ob *store.JobOutbox, d decision, why string) error {
+	var requeues int64
+	if err := r.db.WithContext(ctx).Model(&store.ReconcileDecision{}).
+		Where("media_id = ? AND decision = ?", asset.ID, DecisionRequeue).Count(&requeues).Error; err != nil {
+		return err
+	}
+	if asset.SourceKey == "" {
+		d.kind, d.reason = DecisionFail, why+"，且源文件不存在"
+	} else if int(requeues) >= r.opts.MaxRequeues {
+		d.kind, d.reason = DecisionFail, fmt.Sprintf("%s，已重新投递 %d 次", why, requeues)
+	} else {
+		d.kind, d.reason = DecisionRequeue, why
+	}
+	return r.apply(ctx, asset, ob, d)
+}
+
+func (r *Reconciler) apply(ctx context.Context, asset *store.MediaAsset, ob *store.JobOutbox, d decision) error {
+	switch d.kind {
+	case DecisionRequeue:
+		if err := r.repo.Requeue(ctx, jobPayload(asset, ob)); err != nil {
+			return err
+		}
+		r.relay.Notify()
+	case DecisionFail:
+		if err := r.repo.MarkFailed(ctx, asset.ID, d.reason); err != nil {
+			return err
+		}
+	default:
+		// 等待类结论每轮都会得出，仅在结论变化时记录
+		if r.sameAsLast(ctx, asset.ID, d.kind) {
+			return nil
+		}
+	}
+	r.logger.Printf("reconcile media %d: %s (%s)", asset.ID, d.kind, d.reason)
+	return r.db.WithContext(ctx).Create(&store.ReconcileDecision{
+		MediaID:   asset.ID,
+		JobID:     d.jobID,
+		MessageID: d.messageID,
+		Decision:  d.kind,
+		Reason:    d.reason,
+	}).Error
+}
+
+func (r *Reconciler) sameAsLast(ctx context.Context, mediaID uint, kind string) bool {
+	var last []store.ReconcileDecision
+	if err := r.db.WithContext(ctx).Where("media_id = ?", mediaID).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return false
+	}
+	return len(last) == 1 && last[0].Decision == kind
+}
+
+// jobPayload 优先沿用 outbox 中的原始作业，缺失时按资源记录重建
+func jobPayload(asset *store.MediaAsset, ob *store.JobOutbox) queue.JobPayload {
+	if ob != nil {
+		if payload, err := queue.Decode([]byte(ob.Payload)); err == nil {
+			return payload
+		}
+	}
+	return queue.JobPayload{MediaID: asset.ID, OwnerID: asset.OwnerID, Source: asset.SourceKey, Priority: queue.Priority(asset.Priority), Profile: asset.Profile, Watermark: asset.Watermark}
+}
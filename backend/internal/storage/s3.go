@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3 兼容 S3 协议的对象存储实现（AWS S3 / MinIO 等），所有 key 挂在 prefix 之下
+type S3 struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	baseURL string
+}
+
+func NewS3(cfg S3Config, prefix, baseURL string) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: prefix, baseURL: baseURL}, nil
+}
+
+// EnsureBucket 桶不存在时自动创建，便于本地 MinIO 联调
+func (s *S3) EnsureBucket(ctx context.Context, region string) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{Region: region})
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if contentType == "" {
+		contentType = ContentType(key)
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	// GetObject 是惰性的，通过 Stat 提前暴露 NoSuchKey
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, mapS3Err(err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.prefix+key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, mapS3Err(err)
+	}
+	return s.objectInfo(info), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return mapS3Err(s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var out []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		out = append(out, s.objectInfo(obj))
+	}
+	return out, nil
+}
+
+func (s *S3) URL(key string) string {
+	return joinURL(s.baseURL, key)
+}
+
+func (s *S3) objectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          strings.TrimPrefix(info.Key, s.prefix),
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         fmt.Sprintf(`"%s"`, strings.Trim(info.ETag, `"`)),
+	}
+}
+
+func mapS3Err(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotFound
+	}
+	return err
+}
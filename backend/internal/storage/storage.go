@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage 抽象上传源文件与转码产物的存放位置，key 统一使用 "/" 分隔的相对路径
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// URL 返回对外访问地址（基于配置的公共 base URL）
+	URL(key string) string
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// PutFile 将本地文件写入存储
+func PutFile(ctx context.Context, st Storage, key, localPath string) (int64, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := st.Put(ctx, key, f, info.Size(), ContentType(key)); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// PutDir 将本地目录下的全部文件写入 prefix 下，返回写入的总字节数
+func PutDir(ctx context.Context, st Storage, prefix, dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		n, err := PutFile(ctx, st, path.Join(prefix, filepath.ToSlash(rel)), p)
+		if err != nil {
+			return err
+		}
+		total += n
+		return nil
+	})
+	return total, err
+}
+
+// DeletePrefix 删除 prefix 下的所有对象，返回删除的总字节数
+func DeletePrefix(ctx context.Context, st Storage, prefix string) (int64, error) {
+	objects, err := st.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, obj := range objects {
+		if err := st.Delete(ctx, obj.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return total, err
+		}
+		total += obj.Size
+	}
+	return total, nil
+}
+
+// LocalCopy 返回可供 ffmpeg 直接读取的本地路径。
+// 本地存储直接返回原路径；其他实现下载到 workDir 下的临时文件，cleanup 负责清理。
+func LocalCopy(ctx context.Context, st Storage, key, workDir string) (string, func(), error) {
+	if l, ok := st.(*Local); ok {
+		p, err := l.Path(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(p); err != nil {
+			if os.IsNotExist(err) {
+				return "", nil, ErrNotFound
+			}
+			return "", nil, err
+		}
+		return p, func() {}, nil
+	}
+	rc, err := st.Open(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp(workDir, "source-*"+path.Ext(key))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.Remove(f.Name()) }
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// ContentType 按扩展名推断媒体类型，HLS/DASH 相关类型优先
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".vtt":
+		return "text/vtt"
+	}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func joinURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(key, "/")
+}
@@ -10,34 +10,150 @@ import (
 )
 
 type MediaAsset struct {
-    ID          uint   `gorm:"primaryKey"`
-    OwnerID     string `gorm:"size:64;index"`
-    Status      string `gorm:"size:32;index"`
-    OriginalURL string `gorm:"size:512"`
-    Duration    float64
+    ID          uint     `gorm:"primaryKey"`
+    OwnerID     string   `gorm:"size:64;index"`
+    Status      string   `gorm:"size:32;index"`
+    OriginalURL string   `gorm:"size:512"`
+    SourceKey   string   `gorm:"size:512"` // 上传存储中的源文件 key，远程拉取的资源下载完成后写入
+    FailReason  string   `gorm:"size:512"`
+    Duration    float64  // 源文件时长（秒），由 ffprobe 探测
+    Priority    string   `gorm:"size:16"` // 作业所在的优先级队列
+    Profile     string   `gorm:"size:32"` // 提交时选定的转码配置
+    Loudness    *float64 // 默认音轨的积分响度（LUFS），仅在转码配置开启响度标准化时测量
+    Watermark   string   `gorm:"type:text"` // 上传时按资源覆盖的水印参数（JSON），重新转码时沿用
     CreatedAt   time.Time
     UpdatedAt   time.Time
+    // OutputsSweptAt 失败残留产物的清理时间，再次失败时置空
+    OutputsSweptAt *time.Time
     // 仅维护逻辑关联，不生成外键约束
     Variants []MediaVariant `gorm:"foreignKey:MediaID"`
 }
 
+// MediaVariant 同一资源的 (quality, format, codec) 唯一，重复投递只会覆盖而不会新增
 type MediaVariant struct {
+	ID         uint   `gorm:"primaryKey"`
+	MediaID    uint   `gorm:"index;uniqueIndex:idx_media_variants_identity,priority:1"`
+	Quality    string `gorm:"size:32;uniqueIndex:idx_media_variants_identity,priority:2"`
+	Format     string `gorm:"size:16;uniqueIndex:idx_media_variants_identity,priority:3"`
+	Codec      string `gorm:"size:64;not null;default:'';uniqueIndex:idx_media_variants_identity,priority:4"`
+	StorageKey string `gorm:"size:512"` // 输出存储中的 key，对外 URL 在读取时按 PUBLIC_BASE_URL 拼接
+	Profile    string `gorm:"size:32"`  // 产出该 variant 的转码配置
+	Language   string `gorm:"size:16"`  // 备选音轨的语言与名称，其余 variant 为空
+	Label      string `gorm:"size:64"`
+	CreatedAt  time.Time
+}
+
+// 字幕来源
+const (
+	SubtitleUpload   = "upload"
+	SubtitleEmbedded = "embedded"
+)
+
+// MediaSubtitle 资源的字幕轨：用户上传或从源文件中提取，原始文件保存在上传存储中，
+// 打包后的分段 WebVTT 位于输出目录 subs/<id>/。同一源文件流只记录一条（上传的字幕 StreamIndex 为空）
+type MediaSubtitle struct {
+	ID          uint   `gorm:"primaryKey"`
+	MediaID     uint   `gorm:"index;uniqueIndex:idx_media_subtitles_stream,priority:1"`
+	Language    string `gorm:"size:16"`
+	Label       string `gorm:"size:64"`
+	Origin      string `gorm:"size:16"`
+	StreamIndex *int   `gorm:"uniqueIndex:idx_media_subtitles_stream,priority:2"`
+	SourceKey   string `gorm:"size:512"`
+	StorageKey  string `gorm:"size:512"` // 分段 WebVTT 的媒体播放列表，打包前为空
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TranscodeJob 记录每次作业执行，worker 运行期间定期刷新 HeartbeatAt。
+// QUEUE_BACKEND=db 时同一张表也承载待执行的队列消息（QUEUED / LEASED），ACK 后删除，死信保留为 DEAD
+type TranscodeJob struct {
+	ID             uint   `gorm:"primaryKey"`
+	MediaID        uint   `gorm:"index"`
+	State          string `gorm:"size:32;index;index:idx_transcode_jobs_queue,priority:1"`
+	RetryCount     int
+	LogPath        string `gorm:"size:256"`
+	MessageID      string `gorm:"size:64"`
+	IdempotencyKey string `gorm:"size:64;index"` // 同一作业的重复投递共享同一个 key
+	Consumer       string `gorm:"size:128"`
+	HeartbeatAt    *time.Time
+	Error          string `gorm:"size:1024"`
+	// 以下字段仅用于数据库队列
+	Priority    string     `gorm:"size:16;index:idx_transcode_jobs_queue,priority:2"`
+	AvailableAt *time.Time `gorm:"index:idx_transcode_jobs_queue,priority:3"` // 早于该时间不可领取，用于重试退避
+	LeasedAt    *time.Time // 最近一次领取或续约时间
+	Payload     string     `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// 作业执行状态
+const (
+	JobRunning   = "RUNNING"
+	JobSucceeded = "SUCCEEDED"
+	JobFailed    = "FAILED"
+)
+
+// 数据库队列中的消息状态
+const (
+	JobQueued = "QUEUED"
+	JobLeased = "LEASED"
+	JobDead   = "DEAD"
+)
+
+// JobExecutionStates 执行记录的全部状态，查询执行记录时据此排除队列消息
+var JobExecutionStates = []string{JobRunning, JobSucceeded, JobFailed}
+
+// CDNPurgeAttempt 记录每次 CDN 刷新请求及其结果，便于排查边缘缓存不一致
+type CDNPurgeAttempt struct {
 	ID        uint   `gorm:"primaryKey"`
 	MediaID   uint   `gorm:"index"`
-	Quality   string `gorm:"size:32"`
-	Format    string `gorm:"size:16"`
-	CDNURL    string `gorm:"size:512"`
+	Reason    string `gorm:"size:32"`
+	Targets   string `gorm:"type:text"`
+	Attempt   int
+	Success   bool
+	Error     string `gorm:"size:1024"`
 	CreatedAt time.Time
 }
 
-type TranscodeJob struct {
-	ID         uint   `gorm:"primaryKey"`
-	MediaID    uint   `gorm:"index"`
-	State      string `gorm:"size:32;index"`
-	RetryCount int
-	LogPath    string `gorm:"size:256"`
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+// OwnerUsage 每个 OwnerID 的累计存储与转码用量，用于配额校验
+type OwnerUsage struct {
+	OwnerID          string `gorm:"primaryKey;size:64"`
+	BytesStored      int64
+	TranscodeSeconds float64
+	UpdatedAt        time.Time
+}
+
+// 作业 outbox 状态
+const (
+	OutboxPending = "PENDING"
+	OutboxSent    = "SENT"
+	OutboxDead    = "DEAD" // 无法解析的记录，不再发布
+)
+
+// JobOutbox 与资源记录同事务写入的待投递作业，由 relay 异步发布到队列
+type JobOutbox struct {
+	ID        uint   `gorm:"primaryKey"`
+	MediaID   uint   `gorm:"index"`
+	Payload   string `gorm:"type:text"`
+	Status    string `gorm:"size:16;index"`
+	MessageID string `gorm:"size:64"` // 发布到 Stream 后的消息 ID
+	Attempts  int
+	LastError string `gorm:"size:1024"`
+	CreatedAt time.Time
+	SentAt    *time.Time
+	// NextAttemptAt 发布失败后按次数退避，早于该时间不再尝试；为空时立即可发布
+	NextAttemptAt *time.Time
+}
+
+// ReconcileDecision 卡住作业巡检的每一次处置记录
+type ReconcileDecision struct {
+	ID        uint `gorm:"primaryKey"`
+	MediaID   uint `gorm:"index"`
+	JobID     uint
+	MessageID string `gorm:"size:64"`
+	Decision  string `gorm:"size:32"`
+	Reason    string `gorm:"size:512"`
+	CreatedAt time.Time
 }
 
 func NewDB(dsn string) (*gorm.DB, error) {
@@ -49,7 +165,7 @@ func NewDB(dsn string) (*gorm.DB, error) {
     if err != nil {
         return nil, err
     }
-	if err := db.AutoMigrate(&MediaAsset{}, &MediaVariant{}, &TranscodeJob{}); err != nil {
+	if err := db.AutoMigrate(&MediaAsset{}, &MediaVariant{}, &TranscodeJob{}, &CDNPurgeAttempt{}, &OwnerUsage{}, &JobOutbox{}, &ReconcileDecision{}, &MediaSubtitle{}); err != nil {
 		return nil, err
 	}
 	sqlDB, err := db.DB()
@@ -0,0 +1,220 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"parallel/internal/probe"
+)
+
+// artworkDir 封面与缩略图在输出目录中的子目录
+const artworkDir = "artwork"
+
+// ArtworkOptions 缩略图参数：Interval 为 0 时只生成封面
+type ArtworkOptions struct {
+	Interval     time.Duration
+	Width        int // 缩略图宽度，高度按源宽高比推算
+	Columns      int // 雪碧图每张的列数与行数
+	Rows         int
+	PosterHeight int // 封面最大高度，源分辨率更低时不放大
+
+	// 悬停预览：Preview 为总时长（0 不生成），由 PreviewClips 段代表性片段拼接
+	Preview       time.Duration
+	PreviewClips  int
+	PreviewHeight int
+	PreviewFormat string // mp4 / webp
+}
+
+// Artifacts 生成成功的产物，路径相对于输出目录，未生成的为空
+type Artifacts struct {
+	Poster     string
+	Thumbnails string // 逐张缩略图的 WebVTT 索引
+	Trickplay  string // 雪碧图的 WebVTT 索引（#xywh 定位）
+	Preview    string
+}
+
+// Artwork 转码完成后的附加阶段：跳过黑帧截取封面，按固定间隔截取缩略图并拼成雪碧图，
+// 按场景变化评分挑选片段生成静音预览。
+// 失败只记录日志，不影响视频本身可播放
+type Artwork struct {
+	binary string
+	opts   ArtworkOptions
+	logger *log.Logger
+}
+
+func NewArtwork(binary string, opts ArtworkOptions, logger *log.Logger) *Artwork {
+	if opts.Width <= 0 {
+		opts.Width = 160
+	}
+	if opts.Columns <= 0 {
+		opts.Columns = 10
+	}
+	if opts.Rows <= 0 {
+		opts.Rows = 10
+	}
+	if opts.PosterHeight <= 0 {
+		opts.PosterHeight = 720
+	}
+	if opts.PreviewClips <= 0 {
+		opts.PreviewClips = 3
+	}
+	if opts.PreviewHeight <= 0 {
+		opts.PreviewHeight = 240
+	}
+	if opts.PreviewFormat != "webp" {
+		opts.PreviewFormat = "mp4"
+	}
+	return &Artwork{binary: binary, opts: opts, logger: logger}
+}
+
+func (a *Artwork) Render(ctx context.Context, source, outDir string, info *probe.Result) Artifacts {
+	var out Artifacts
+	dir := filepath.Join(outDir, artworkDir)
+	if err := os.MkdirAll(filepath.Join(dir, "thumbs"), 0o755); err != nil {
+		a.logger.Printf("artwork: %v", err)
+		return out
+	}
+	src := firstVideo(info)
+	if err := a.poster(ctx, source, dir, info.Duration, src); err != nil {
+		a.logger.Printf("artwork poster %s: %v", source, err)
+	} else {
+		out.Poster = artworkDir + "/poster.jpg"
+	}
+	if a.opts.Preview > 0 && info.Duration > 0 {
+		if path, err := a.preview(ctx, source, dir, info.Duration); err != nil {
+			a.logger.Printf("artwork preview %s: %v", source, err)
+		} else {
+			out.Preview = path
+		}
+	}
+	if a.opts.Interval <= 0 || info.Duration <= 0 {
+		return out
+	}
+	if err := a.thumbnails(ctx, source, dir, src); err != nil {
+		a.logger.Printf("artwork thumbnails %s: %v", source, err)
+		return out
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "thumbs", "*.jpg"))
+	if len(entries) == 0 {
+		return out
+	}
+	height := a.thumbHeight(src)
+	thumbs, sprite := a.vtt(len(entries), info.Duration, height)
+	if err := os.WriteFile(filepath.Join(dir, "thumbnails.vtt"), []byte(thumbs), 0o644); err == nil {
+		out.Thumbnails = artworkDir + "/thumbnails.vtt"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sprite.vtt"), []byte(sprite), 0o644); err == nil {
+		out.Trickplay = artworkDir + "/sprite.vtt"
+	}
+	return out
+}
+
+// poster 从时长 10% 处开始，丢弃黑色像素占比超过 90% 的帧，再由 thumbnail 滤镜在后续帧中挑选最具代表性的一帧。
+// 整段都是黑帧时退回截取起始位置的一帧
+func (a *Artwork) poster(ctx context.Context, source, dir string, duration float64, src sourceVideo) error {
+	scale := ""
+	if src.height > a.opts.PosterHeight {
+		scale = fmt.Sprintf(",scale=-2:%d", a.opts.PosterHeight)
+	}
+	start := strconv.FormatFloat(duration*0.1, 'f', 3, 64)
+	path := filepath.Join(dir, "poster.jpg")
+	build := func(filter string) Command {
+		return Command{
+			Global: Options{}.Flag("y"),
+			Inputs: []Input{{Options: Options{}.Set("ss", start), Path: source}},
+			Outputs: []Output{{
+				Maps:     []string{"0:v:0"},
+				Encoders: []Encoder{{Stream: "v", Options: Options{}.Set("filter", filter).Set("frames", "1")}},
+				Options:  Options{}.Flag("an"),
+				Path:     path,
+			}},
+		}
+	}
+	skipBlack := "blackframe=amount=0:threshold=32," +
+		"metadata=mode=select:key=lavfi.blackframe.pblack:value=90:function=less," +
+		"thumbnail=50" + scale
+	if err := runFFmpeg(ctx, a.binary, build(skipBlack)); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return runFFmpeg(ctx, a.binary, build("null"+scale))
+}
+
+// thumbnails 一次解码同时输出逐张缩略图（thumbs/0001.jpg 起）与 Columns x Rows 拼接的雪碧图（sprite_001.jpg 起）
+func (a *Artwork) thumbnails(ctx context.Context, source, dir string, src sourceVideo) error {
+	interval := strconv.FormatFloat(a.opts.Interval.Seconds(), 'f', -1, 64)
+	cmd := Command{
+		Global: Options{}.Flag("y"),
+		Inputs: []Input{{Path: source}},
+		Filters: []Filter{
+			{Inputs: []string{"0:v:0"}, Expr: fmt.Sprintf("fps=1/%s,scale=%d:%d,split=2", interval, a.opts.Width, a.thumbHeight(src)), Outputs: []string{"t", "s"}},
+			{Inputs: []string{"s"}, Expr: fmt.Sprintf("tile=%dx%d", a.opts.Columns, a.opts.Rows), Outputs: []string{"sprite"}},
+		},
+		Outputs: []Output{
+			{Maps: []string{"[t]"}, Encoders: []Encoder{{Stream: "v", Options: Options{}.Set("q", "5")}}, Path: filepath.Join(dir, "thumbs", "%04d.jpg")},
+			{Maps: []string{"[sprite]"}, Encoders: []Encoder{{Stream: "v", Options: Options{}.Set("q", "5")}}, Path: filepath.Join(dir, "sprite_%03d.jpg")},
+		},
+	}
+	return runFFmpeg(ctx, a.binary, cmd)
+}
+
+// thumbHeight 按源宽高比推算缩略图高度（取偶数），源分辨率未知时按 16:9
+func (a *Artwork) thumbHeight(src sourceVideo) int {
+	if src.width <= 0 || src.height <= 0 {
+		return a.opts.Width * 9 / 16 / 2 * 2
+	}
+	return (a.opts.Width*src.height/src.width + 1) / 2 * 2
+}
+
+// vtt 生成两份索引：逐张缩略图，以及雪碧图中第 i 张所在的文件与 #xywh 区域。
+// URL 相对于 VTT 文件本身，换 CDN 域名无需重写
+func (a *Artwork) vtt(count int, duration float64, height int) (thumbs, sprite string) {
+	var t, s strings.Builder
+	t.WriteString("WEBVTT\n")
+	s.WriteString("WEBVTT\n")
+	step := a.opts.Interval.Seconds()
+	perSheet := a.opts.Columns * a.opts.Rows
+	for i := 0; i < count; i++ {
+		start := float64(i) * step
+		if start >= duration {
+			break
+		}
+		end := start + step
+		if end > duration {
+			end = duration
+		}
+		cue := fmt.Sprintf("\n%s --> %s\n", vttTime(start), vttTime(end))
+		fmt.Fprintf(&t, "%sthumbs/%04d.jpg\n", cue, i+1)
+		pos := i % perSheet
+		x, y := pos%a.opts.Columns*a.opts.Width, pos/a.opts.Columns*height
+		fmt.Fprintf(&s, "%ssprite_%03d.jpg#xywh=%d,%d,%d,%d\n", cue, i/perSheet+1, x, y, a.opts.Width, height)
+	}
+	return t.String(), s.String()
+}
+
+// vttTime 格式化为 WebVTT 时间戳 HH:MM:SS.mmm
+func vttTime(sec float64) string {
+	ms := int64(sec*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// runFFmpeg 执行命令，失败时以 stderr 末尾作为错误信息
+func runFFmpeg(ctx context.Context, binary string, c Command) error {
+	cmd := exec.CommandContext(ctx, binary, c.Args()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg 失败: %v: %s", err, tail(stderr.String(), 400))
+	}
+	return nil
+}
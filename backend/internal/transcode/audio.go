@@ -0,0 +1,111 @@
+package transcode
+
+import (
+	"fmt"
+	"strings"
+
+	"parallel/internal/probe"
+	"parallel/internal/profile"
+)
+
+// audioGroup 主播放列表中备选音轨所在的 GROUP-ID
+const audioGroup = "audio"
+
+// audioTrack 选中输出的一条源音轨
+type audioTrack struct {
+	stream   int // 源文件中的流序号，映射为 0:<stream>
+	language string
+	name     string
+	channels int
+	filter   string // 下混与响度标准化滤镜链，由 prepareAudio 填充
+}
+
+// audioDir HLS 打包时第 i 条音轨的输出子目录
+func audioDir(i int) string {
+	return fmt.Sprintf("audio/%d", i)
+}
+
+// audioQuality 第 i 条音轨 variant 的 quality
+func audioQuality(i int) string {
+	return fmt.Sprintf("audio-%d", i)
+}
+
+// selectAudio 按 Audio.Languages 挑选音轨，一条都没有匹配时退回首条音轨，避免输出无声视频。
+// 源文件中标记为默认的音轨排在最前，作为主播放列表中 DEFAULT=YES 的音轨；
+// 名称取 title 标签，没有时取语言，同名时追加序号
+func selectAudio(info *probe.Result, a profile.Audio) []audioTrack {
+	streams := info.StreamsOf("audio")
+	var picked []probe.Stream
+	for _, st := range streams {
+		if len(a.Languages) == 0 || hasLanguage(a.Languages, st.Tags["language"]) {
+			picked = append(picked, st)
+		}
+	}
+	if len(picked) == 0 && len(streams) > 0 {
+		picked = streams[:1]
+	}
+	for i, st := range picked {
+		if st.Disposition["default"] != 1 {
+			continue
+		}
+		if i > 0 {
+			reordered := append([]probe.Stream{st}, picked[:i]...)
+			picked = append(reordered, picked[i+1:]...)
+		}
+		break
+	}
+	tracks := make([]audioTrack, 0, len(picked))
+	names := map[string]int{}
+	for i, st := range picked {
+		lang := st.Tags["language"]
+		if lang == "" {
+			lang = "und"
+		}
+		name := strings.TrimSpace(st.Tags["title"])
+		if name == "" {
+			name = lang
+			if lang == "und" {
+				name = fmt.Sprintf("Audio %d", i+1)
+			}
+		}
+		name = quoteless(name)
+		if names[name]++; names[name] > 1 {
+			name = fmt.Sprintf("%s (%d)", name, names[name])
+		}
+		channels := a.OutputChannels()
+		if channels == 0 {
+			channels = st.Channels
+		}
+		tracks = append(tracks, audioTrack{stream: st.Index, language: lang, name: name, channels: channels})
+	}
+	return tracks
+}
+
+func hasLanguage(list []string, lang string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// audioRendition 主播放列表中一条备选音轨的 EXT-X-MEDIA，语言未知时省略 LANGUAGE
+func audioRendition(t audioTrack, isDefault bool, uri string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\"", audioGroup, t.name)
+	if t.language != "und" {
+		fmt.Fprintf(&b, ",LANGUAGE=\"%s\"", quoteless(t.language))
+	}
+	if isDefault {
+		b.WriteString(",DEFAULT=YES")
+	} else {
+		b.WriteString(",DEFAULT=NO")
+	}
+	b.WriteString(",AUTOSELECT=YES")
+	if t.channels > 0 {
+		fmt.Fprintf(&b, ",CHANNELS=\"%d\"", t.channels)
+	}
+	fmt.Fprintf(&b, ",URI=\"%s\"\n", uri)
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package transcode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"parallel/internal/profile"
+)
+
+// cmafManifest DASH 清单文件名；HLS 主播放列表沿用 index.m3u8
+const cmafManifest = "manifest.mpd"
+
+// cmafMediaPlaylist dash 封装器为第 i 个输出流（先各档视频，后各条音轨）写出的 HLS 媒体播放列表
+func cmafMediaPlaylist(i int) string {
+	return fmt.Sprintf("media_%d.m3u8", i)
+}
+
+// adaptationSets 按编码族把视频表示分组（输出流下标与阶梯顺序一致），
+// DASH 客户端只在同一自适应集内切换码率，不会在 h264 与 hevc 之间来回切换
+func adaptationSets(ladder []profile.Rung) string {
+	var families []string
+	streams := map[string][]string{}
+	for i, rung := range ladder {
+		f := rung.Family()
+		if _, ok := streams[f]; !ok {
+			families = append(families, f)
+		}
+		streams[f] = append(streams[f], strconv.Itoa(i))
+	}
+	sets := make([]string, 0, len(families))
+	for id, f := range families {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=%s", id, strings.Join(streams[f], ",")))
+	}
+	return strings.Join(sets, " ")
+}
+
+// cmafCommand 一次编码出各档 fMP4（CMAF）分片，由 dash 封装器同时写出 DASH MPD
+// 与引用同一批分片的 HLS 播放列表。同一编码的各档视频放在同一个自适应集中，
+// 每条音轨单独一个自适应集（语言取自源文件的流元数据）；水印（如有）在拆分各档之前叠加
+func cmafCommand(source, outDir string, p profile.Profile, audio []audioTrack, wm *watermark) Command {
+	cmd := Command{
+		Global: Options{}.Flag("y"),
+		Inputs: []Input{{Path: source}},
+	}
+	cmd.Filters = append(cmd.Filters, ladderFilters(p, wm.apply(&cmd, "0:v:0"))...)
+
+	out := Output{Format: "dash", Path: filepath.Join(outDir, cmafManifest)}
+	for i, rung := range p.Ladder {
+		out.Maps = append(out.Maps, fmt.Sprintf("[v%d]", i))
+		out.Encoders = append(out.Encoders, videoEncoder(fmt.Sprintf("v:%d", i), p, rung))
+	}
+	sets := adaptationSets(p.Ladder)
+	next := strings.Count(sets, "id=")
+	for i, t := range audio {
+		out.Maps = append(out.Maps, fmt.Sprintf("0:%d", t.stream))
+		out.Encoders = append(out.Encoders, audioEncoder(fmt.Sprintf("a:%d", i), p.Audio, t.filter))
+		sets += fmt.Sprintf(" id=%d,streams=%d", next+i, len(p.Ladder)+i)
+	}
+	out.Muxer = Options{}.
+		Set("seg_duration", strconv.Itoa(p.SegmentSeconds)).
+		Set("use_template", "1").
+		Set("use_timeline", "1").
+		Set("init_seg_name", "init-$RepresentationID$.$ext$").
+		Set("media_seg_name", "chunk-$RepresentationID$-$Number%05d$.$ext$").
+		Set("adaptation_sets", sets).
+		Set("hls_playlist", "1").
+		Set("hls_master_name", "index.m3u8")
+	cmd.Outputs = append(cmd.Outputs, out)
+	return cmd
+}
@@ -0,0 +1,113 @@
+package transcode
+
+import (
+	"fmt"
+
+	"parallel/internal/probe"
+	"parallel/internal/profile"
+)
+
+// sourceVideo 源文件首路视频流的分辨率，用于推算各档输出尺寸与 CODECS
+type sourceVideo struct {
+	width, height int
+}
+
+func firstVideo(info *probe.Result) sourceVideo {
+	if videos := info.StreamsOf("video"); len(videos) > 0 {
+		return sourceVideo{width: videos[0].Width, height: videos[0].Height}
+	}
+	return sourceVideo{}
+}
+
+// size 返回该档的输出分辨率，与 scale=-2:<height> 一致按源宽高比取偶数宽度；源分辨率未知时返回 0
+func (s sourceVideo) size(rung profile.Rung) (int, int) {
+	if s.width <= 0 || s.height <= 0 {
+		return 0, 0
+	}
+	if rung.Height <= 0 {
+		return s.width, s.height
+	}
+	w := (s.width*rung.Height/s.height + 1) / 2 * 2
+	return w, rung.Height
+}
+
+// encoderFor 返回该档的 ffmpeg 编码器、预设与附加选项。hevc 以 hvc1 标记写入 fMP4，
+// 否则 Safari 等播放器不识别
+func encoderFor(p profile.Profile, rung profile.Rung) (codec, preset string, extra Options) {
+	preset = p.Preset
+	switch rung.Family() {
+	case profile.CodecHEVC:
+		codec, extra = "libx265", Options{}.Set("tag", "hvc1")
+	case profile.CodecAV1:
+		// libsvtav1 的预设为 0-13 的数字，不能沿用 x264 风格的名称
+		codec, preset = "libsvtav1", "8"
+	default:
+		codec = p.VideoCodec
+	}
+	if rung.Preset != "" {
+		preset = rung.Preset
+	}
+	return codec, preset, extra
+}
+
+// videoCodecString 返回 RFC 6381 形式的 CODECS 取值，level 按输出高度估算：
+// h264 为 High profile，hevc 为 Main profile，av1 为 Main profile 8bit
+func videoCodecString(family string, height int) string {
+	var tier int
+	switch {
+	case height <= 480:
+	case height <= 720:
+		tier = 1
+	case height <= 1080:
+		tier = 2
+	case height <= 1440:
+		tier = 3
+	default:
+		tier = 4
+	}
+	switch family {
+	case profile.CodecHEVC:
+		levels := []int{90, 93, 120, 150, 153}
+		return fmt.Sprintf("hvc1.1.6.L%d.90", levels[tier])
+	case profile.CodecAV1:
+		levels := []int{4, 5, 8, 12, 13}
+		return fmt.Sprintf("av01.0.%02dM.08", levels[tier])
+	}
+	levels := []int{0x1e, 0x1f, 0x28, 0x32, 0x33}
+	return fmt.Sprintf("avc1.6400%02x", levels[tier])
+}
+
+// audioCodecString 未知编码返回空串，此时 CODECS 只声明视频
+func audioCodecString(codec string) string {
+	switch codec {
+	case "aac", "libfdk_aac":
+		return "mp4a.40.2"
+	case "libopus", "opus":
+		return "opus"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	case "flac":
+		return "fLaC"
+	}
+	return ""
+}
+
+// streamCodecs 主播放列表中某档的 CODECS 属性值；源分辨率未知且该档保持源分辨率时按 1080p 估算
+func streamCodecs(p profile.Profile, rung profile.Rung, src sourceVideo, hasAudio bool) string {
+	_, height := src.size(rung)
+	if height == 0 {
+		height = rung.Height
+	}
+	if height == 0 {
+		height = 1080
+	}
+	codecs := videoCodecString(rung.Family(), height)
+	if hasAudio {
+		if audio := audioCodecString(p.Audio.Codec); audio != "" {
+			codecs += "," + audio
+		}
+	}
+	return codecs
+}
@@ -0,0 +1,131 @@
+package transcode
+
+import "strings"
+
+// Command 声明式描述一次 ffmpeg 调用。Args 只按字段声明顺序渲染、不依赖 map 遍历，
+// 相同的 Command 总是得到相同的 argv，可直接与期望的参数列表逐项比较
+type Command struct {
+	Global  Options // 全局选项，如 -y
+	Inputs  []Input
+	Filters []Filter // 渲染为 -filter_complex，多条以 ";" 连接
+	Outputs []Output
+}
+
+// Input 输入文件及其之前的输入选项
+type Input struct {
+	Options Options
+	Path    string
+}
+
+// Filter filter_complex 中的一条链：[in...]expr[out...]
+type Filter struct {
+	Inputs  []string // 如 "0:v"、"v0"，渲染时加方括号
+	Expr    string
+	Outputs []string
+}
+
+// Output 一个输出文件：流映射、各流编码器、封装格式与封装选项
+type Output struct {
+	Maps     []string // -map，引用 filter 输出时写作 "[v0]"
+	Encoders []Encoder
+	Options  Options // 其他输出选项，渲染在编码器之后
+	Format   string  // -f
+	Muxer    Options // 封装器选项，渲染在 -f 之后
+	Path     string
+}
+
+// Encoder 作用于某个流说明符（如 "v"、"v:1"、"a:0"）的编码参数，
+// Options 中的每项渲染为 -<name>:<stream> <value>
+type Encoder struct {
+	Stream  string
+	Codec   string
+	Options Options
+}
+
+// Option Value 为空时只输出选项名
+type Option struct {
+	Name  string
+	Value string
+}
+
+type Options []Option
+
+// Set 返回追加了带值选项的新列表，value 为空时原样返回，便于按可选参数声明。
+// 总是复制而不是原地 append，同一组公共选项可安全地派生给多个输出
+func (o Options) Set(name, value string) Options {
+	if value == "" {
+		return o
+	}
+	return o.with(Option{Name: name, Value: value})
+}
+
+// Flag 返回追加了不带值选项的新列表
+func (o Options) Flag(name string) Options {
+	return o.with(Option{Name: name})
+}
+
+func (o Options) with(opt Option) Options {
+	out := make(Options, len(o), len(o)+1)
+	copy(out, o)
+	return append(out, opt)
+}
+
+func (o Options) render(args []string, suffix string) []string {
+	for _, opt := range o {
+		args = append(args, "-"+opt.Name+suffix)
+		if opt.Value != "" {
+			args = append(args, opt.Value)
+		}
+	}
+	return args
+}
+
+func (f Filter) String() string {
+	var b strings.Builder
+	for _, in := range f.Inputs {
+		b.WriteString("[" + in + "]")
+	}
+	b.WriteString(f.Expr)
+	for _, out := range f.Outputs {
+		b.WriteString("[" + out + "]")
+	}
+	return b.String()
+}
+
+// Args 渲染为 ffmpeg 参数列表（不含可执行文件本身）
+func (c Command) Args() []string {
+	args := c.Global.render(nil, "")
+	for _, in := range c.Inputs {
+		args = in.Options.render(args, "")
+		args = append(args, "-i", in.Path)
+	}
+	if len(c.Filters) > 0 {
+		chains := make([]string, 0, len(c.Filters))
+		for _, f := range c.Filters {
+			chains = append(chains, f.String())
+		}
+		args = append(args, "-filter_complex", strings.Join(chains, ";"))
+	}
+	for _, out := range c.Outputs {
+		for _, m := range out.Maps {
+			args = append(args, "-map", m)
+		}
+		for _, enc := range out.Encoders {
+			suffix := ""
+			if enc.Stream != "" {
+				suffix = ":" + enc.Stream
+			}
+			if enc.Codec != "" {
+				args = append(args, "-c"+suffix, enc.Codec)
+			}
+			args = enc.Options.render(args, suffix)
+		}
+		args = out.Options.render(args, "")
+		if out.Format != "" {
+			args = append(args, "-f", out.Format)
+		}
+		args = out.Muxer.render(args, "")
+		args = append(args, out.Path)
+	}
+	return args
+}
@@ -0,0 +1,86 @@
+package transcode
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"parallel/internal/profile"
+)
+
+var update = flag.Bool("update", false, "重新生成 testdata 下的 golden 文件")
+
+// checkGolden 比对 argv 与 testdata/<name>.golden，每行一个参数；-update 时改写 golden 文件
+func checkGolden(t *testing.T, name string, args []string) {
+	t.Helper()
+	got := strings.Join(args, "\n") + "\n"
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 golden 文件失败（可用 -update 生成）: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s argv 与 golden 不一致\n--- got\n%s--- want\n%s", name, got, want)
+	}
+}
+
+func ladderProfile() profile.Profile {
+	return profile.Profile{
+		Name:           "ladder",
+		VideoCodec:     "libx264",
+		Preset:         "medium",
+		Container:      profile.ContainerFMP4,
+		Packaging:      profile.PackagingHLS,
+		SegmentSeconds: 6,
+		Audio:          profile.Audio{Codec: "aac", Bitrate: 128, SampleRate: 48000, ChannelLayout: "stereo"},
+		Ladder: []profile.Rung{
+			{Name: "1080p", Height: 1080, VideoBitrate: 5000, MaxBitrate: 6000},
+			{Name: "720p", Height: 720, VideoBitrate: 2800},
+			{Name: "source", VideoBitrate: 8000},
+		},
+	}
+}
+
+func TestHLSCommandBuiltin(t *testing.T) {
+	audio := []audioTrack{{stream: 1, language: "und", name: "Audio 1"}}
+	cmd := hlsCommand("/work/src.mp4", "/work/out", profile.Builtin(), audio, nil)
+	checkGolden(t, "hls_builtin", cmd.Args())
+}
+
+func TestHLSCommandLadderWithAudioAndWatermark(t *testing.T) {
+	p := ladderProfile()
+	audio := []audioTrack{
+		{stream: 2, language: "eng", name: "English", channels: 2, filter: "aresample=ochl=stereo"},
+		{stream: 1, language: "chi", name: "chi", channels: 2, filter: "aresample=ochl=stereo,loudnorm=I=-23:TP=-1:LRA=7:measured_I=-30.1:measured_TP=-5.2:measured_LRA=4.0:measured_thresh=-40.5:offset=0.3:linear=true,aresample=48000"},
+	}
+	wm := newWatermark(&profile.Watermark{
+		Image:    "/etc/parallel/logo.png",
+		Text:     "preview",
+		Position: profile.PositionBottomRight,
+		Opacity:  0.5,
+		Scale:    0.05,
+		Margin:   0.03,
+	}, "/work/watermark.txt", sourceVideo{width: 1920, height: 1080})
+	cmd := hlsCommand("/work/src.mp4", "/work/out", p, audio, wm)
+	checkGolden(t, "hls_ladder_watermark", cmd.Args())
+}
+
+func TestCMAFCommandMixedCodecs(t *testing.T) {
+	p := ladderProfile()
+	p.Packaging = profile.PackagingCMAF
+	p.Ladder = []profile.Rung{
+		{Name: "1080p", Height: 1080, VideoBitrate: 5000},
+		{Name: "1080p", Codec: profile.CodecHEVC, Height: 1080, VideoBitrate: 3000},
+		{Name: "720p", Codec: profile.CodecAV1, Preset: "8", Height: 720, VideoBitrate: 1500},
+	}
+	audio := []audioTrack{{stream: 1, language: "eng", name: "eng", channels: 2}}
+	cmd := cmafCommand("/work/src.mp4", "/work/out", p, audio, nil)
+	checkGolden(t, "cmaf_mixed_codecs", cmd.Args())
+}
@@ -0,0 +1,165 @@
+package transcode
+
+import (
+	"sync"
+
+	"parallel/internal/queue"
+)
+
+// pendingJob 已从队列领取、等待执行的作业
+type pendingJob struct {
+	msg     queue.Message
+	payload queue.JobPayload
+}
+
+// fairQueue 按 OwnerID 分桶缓冲已领取的作业，轮询各 owner 依次取出，
+// 避免单个用户批量提交的作业占满 worker；同一 owner 的并发数不超过 maxPerOwner（0 表示不限）
+type fairQueue struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	owners      []string // 有待执行作业的 owner，按加入顺序轮询
+	next        int
+	buckets     map[string][]*pendingJob
+	running     map[string]int
+	size        int
+	maxPerOwner int
+	closed      bool
+}
+
+func newFairQueue(maxPerOwner int) *fairQueue {
+	q := &fairQueue{
+		buckets:     map[string][]*pendingJob{},
+		running:     map[string]int{},
+		maxPerOwner: maxPerOwner,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push 加入 owner 的子队列：同一 owner 内高优先级在前，同级按领取顺序
+func (q *fairQueue) push(j *pendingJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	owner := j.payload.OwnerID
+	bucket, ok := q.buckets[owner]
+	if !ok {
+		q.owners = append(q.owners, owner)
+	}
+	i := len(bucket)
+	for i > 0 && bucket[i-1].payload.Priority.Rank() > j.payload.Priority.Rank() {
+		i--
+	}
+	bucket = append(bucket, nil)
+	copy(bucket[i+1:], bucket[i:])
+	bucket[i] = j
+	q.buckets[owner] = bucket
+	q.size++
+	q.cond.Broadcast()
+}
+
+// pop 阻塞直到有 owner 未达并发上限的作业可执行；队列关闭后返回 nil
+func (q *fairQueue) pop() *pendingJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return nil
+		}
+		for n := 0; n < len(q.owners); n++ {
+			i := (q.next + n) % len(q.owners)
+			owner := q.owners[i]
+			if q.maxPerOwner > 0 && q.running[owner] >= q.maxPerOwner {
+				continue
+			}
+			bucket := q.buckets[owner]
+			j := bucket[0]
+			q.running[owner]++
+			q.size--
+			if len(bucket) == 1 {
+				delete(q.buckets, owner)
+				q.owners = append(q.owners[:i], q.owners[i+1:]...)
+				q.next = i
+			} else {
+				q.buckets[owner] = bucket[1:]
+				q.next = i + 1
+			}
+			if len(q.owners) > 0 {
+				q.next %= len(q.owners)
+			} else {
+				q.next = 0
+			}
+			return j
+		}
+		q.cond.Wait()
+	}
+}
+
+// done 作业执行结束，释放 owner 的并发名额
+func (q *fairQueue) done(owner string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running[owner] <= 1 {
+		delete(q.running, owner)
+	} else {
+		q.running[owner]--
+	}
+	q.cond.Broadcast()
+}
+
+// remove 移除租约已丢失的缓冲作业
+func (q *fairQueue) remove(j *pendingJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	owner := j.payload.OwnerID
+	bucket := q.buckets[owner]
+	for i, b := range bucket {
+		if b != j {
+			continue
+		}
+		q.size--
+		if len(bucket) > 1 {
+			q.buckets[owner] = append(bucket[:i], bucket[i+1:]...)
+			return
+		}
+		delete(q.buckets, owner)
+		for k, o := range q.owners {
+			if o == owner {
+				q.owners = append(q.owners[:k], q.owners[k+1:]...)
+				if k < q.next {
+					q.next--
+				}
+				break
+			}
+		}
+		if len(q.owners) > 0 {
+			q.next %= len(q.owners)
+		} else {
+			q.next = 0
+		}
+		return
+	}
+}
+
+// buffered 返回全部缓冲中的作业，用于续约
+func (q *fairQueue) buffered() []*pendingJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]*pendingJob, 0, q.size)
+	for _, owner := range q.owners {
+		out = append(out, q.buckets[owner]...)
+	}
+	return out
+}
+
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
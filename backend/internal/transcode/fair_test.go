@@ -0,0 +1,142 @@
+package transcode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"parallel/internal/queue"
+)
+
+func fairJob(id, owner string, p queue.Priority) *pendingJob {
+	return &pendingJob{msg: queue.Message{ID: id}, payload: queue.JobPayload{OwnerID: owner, Priority: p}}
+}
+
+// popIDs 依次取出 n 个作业并立即释放并发名额
+func popIDs(q *fairQueue, n int) string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		j := q.pop()
+		ids = append(ids, j.msg.ID)
+		q.done(j.payload.OwnerID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// popWithin 在 d 内取出一个作业，超时返回 nil
+func popWithin(q *fairQueue, d time.Duration) *pendingJob {
+	ch := make(chan *pendingJob, 1)
+	go func() { ch <- q.pop() }()
+	select {
+	case j := <-ch:
+		return j
+	case <-time.After(d):
+		q.close()
+		<-ch
+		return nil
+	}
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []*pendingJob
+		want string
+	}{
+		{
+			name: "按 owner 轮询",
+			jobs: []*pendingJob{fairJob("a1", "a", ""), fairJob("a2", "a", ""), fairJob("a3", "a", ""), fairJob("b1", "b", ""), fairJob("c1", "c", "")},
+			want: "a1,b1,c1,a2,a3",
+		},
+		{
+			name: "同一 owner 内高优先级在前",
+			jobs: []*pendingJob{fairJob("a1", "a", queue.PriorityBulk), fairJob("a2", "a", queue.PriorityHigh), fairJob("b1", "b", queue.PriorityBulk), fairJob("a3", "a", queue.PriorityNormal)},
+			want: "a2,b1,a3,a1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFairQueue(0)
+			for _, j := range tt.jobs {
+				q.push(j)
+			}
+			if got := popIDs(q, len(tt.jobs)); got != tt.want {
+				t.Fatalf("出队顺序 = %s, want %s", got, tt.want)
+			}
+			if q.len() != 0 {
+				t.Fatalf("len = %d", q.len())
+			}
+		})
+	}
+}
+
+func TestFairQueuePerOwnerCap(t *testing.T) {
+	q := newFairQueue(1)
+	q.push(fairJob("a1", "a", ""))
+	q.push(fairJob("a2", "a", ""))
+	q.push(fairJob("b1", "b", ""))
+
+	first := q.pop()
+	second := q.pop()
+	if first.msg.ID != "a1" || second.msg.ID != "b1" {
+		t.Fatalf("出队 = %s, %s; owner a 达到上限后应跳到 b", first.msg.ID, second.msg.ID)
+	}
+	if j := popWithin(q, 50*time.Millisecond); j != nil {
+		t.Fatalf("owner a 达到并发上限时仍取出了 %s", j.msg.ID)
+	}
+}
+
+func TestFairQueueDoneReleasesSlot(t *testing.T) {
+	q := newFairQueue(1)
+	q.push(fairJob("a1", "a", ""))
+	q.push(fairJob("a2", "a", ""))
+	if j := q.pop(); j.msg.ID != "a1" {
+		t.Fatalf("pop = %s", j.msg.ID)
+	}
+
+	ch := make(chan *pendingJob, 1)
+	go func() { ch <- q.pop() }()
+	select {
+	case j := <-ch:
+		t.Fatalf("释放名额前取出了 %s", j.msg.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.done("a")
+	select {
+	case j := <-ch:
+		if j.msg.ID != "a2" {
+			t.Fatalf("pop = %s, want a2", j.msg.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("done 之后 pop 仍未返回")
+	}
+}
+
+func TestFairQueueRemoveBuffered(t *testing.T) {
+	q := newFairQueue(0)
+	a1, a2, b1, c1 := fairJob("a1", "a", ""), fairJob("a2", "a", ""), fairJob("b1", "b", ""), fairJob("c1", "c", "")
+	for _, j := range []*pendingJob{a1, a2, b1, c1} {
+		q.push(j)
+	}
+	q.remove(a2)
+	q.remove(b1)
+	// 已不在缓冲区中的作业重复移除不影响计数
+	q.remove(b1)
+	if q.len() != 2 {
+		t.Fatalf("len = %d, want 2", q.len())
+	}
+	var ids []string
+	for _, j := range q.buffered() {
+		ids = append(ids, j.msg.ID)
+	}
+	if got := strings.Join(ids, ","); got != "a1,c1" {
+		t.Fatalf("buffered = %s, want a1,c1", got)
+	}
+	if got := popIDs(q, 2); got != "a1,c1" {
+		t.Fatalf("出队顺序 = %s, want a1,c1", got)
+	}
+	q.close()
+	if j := q.pop(); j != nil {
+		t.Fatalf("关闭后 pop = %s", j.msg.ID)
+	}
+}
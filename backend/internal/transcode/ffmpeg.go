@@ -7,60 +7,393 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"parallel/internal/media"
+	"parallel/internal/probe"
+	"parallel/internal/profile"
 	"parallel/internal/queue"
+	"parallel/internal/quota"
+	"parallel/internal/storage"
+	"parallel/pkg/textutil"
 )
 
 type FFmpeg struct {
 	binary    string
-	outputDir string
+	workDir   string
+	sources   storage.Storage
+	outputs   storage.Storage
 	repo      *media.Repository
+	quota     *quota.Service
+	profiles  *profile.Registry
+	prober    *probe.Prober
+	artwork   *Artwork // 为 nil 时不生成封面与缩略图
+	subtitles *Subtitles
 }
 
-func NewFFmpeg(binary, outputDir string, repo *media.Repository) *FFmpeg {
-	return &FFmpeg{binary: binary, outputDir: outputDir, repo: repo}
+func NewFFmpeg(binary, workDir string, sources, outputs storage.Storage, repo *media.Repository, quota *quota.Service, profiles *profile.Registry, prober *probe.Prober, artwork *Artwork, subtitles *Subtitles) *FFmpeg {
+	return &FFmpeg{binary: binary, workDir: workDir, sources: sources, outputs: outputs, repo: repo, quota: quota, profiles: profiles, prober: prober, artwork: artwork, subtitles: subtitles}
+}
+
+// Reject 作业未通过准入（如配额不足）时直接标记失败
+func (f *FFmpeg) Reject(ctx context.Context, payload queue.JobPayload, reason error) {
+	_ = f.repo.MarkFailed(ctx, payload.MediaID, reason.Error())
 }
 
 func (f *FFmpeg) Process(ctx context.Context, payload queue.JobPayload) error {
-	if _, err := os.Stat(payload.Source); err != nil {
+	prof, err := f.profiles.Get(payload.Profile)
+	if err == nil {
+		prof, err = prof.WithWatermark(payload.Watermark)
+	}
+	if err != nil {
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	source, cleanup, err := storage.LocalCopy(ctx, f.sources, payload.Source, f.workDir)
+	if err != nil {
 		// 标记失败以避免一直停留在 PROCESSING
-		_ = f.repo.UpdateStatus(ctx, payload.MediaID, media.StatusFailed)
-		return fmt.Errorf("源文件不可访问: %w", err)
+		err = fmt.Errorf("源文件不可访问: %w", err)
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	defer cleanup()
+	info, err := f.prober.Probe(ctx, source)
+	if err != nil {
+		err = fmt.Errorf("源文件探测失败: %w", err)
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
 	}
-	outDir := filepath.Join(f.outputDir, fmt.Sprintf("media-%d", payload.MediaID))
-	if err := os.MkdirAll(outDir, 0o755); err != nil {
+	// 先输出到本地临时目录，成功后再整体写入存储，避免存储中留下半成品
+	outDir, err := os.MkdirTemp(f.workDir, fmt.Sprintf("media-%d-", payload.MediaID))
+	if err != nil {
 		// 标记失败
-		_ = f.repo.UpdateStatus(ctx, payload.MediaID, media.StatusFailed)
-		return err
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	defer os.RemoveAll(outDir)
+	src, audio := firstVideo(info), selectAudio(info, prof.Audio)
+	started := time.Now()
+	loudness, err := prepareAudio(ctx, f.binary, source, prof.Audio, audio)
+	_ = f.quota.AddTranscodeSeconds(context.WithoutCancel(ctx), payload.OwnerID, time.Since(started).Seconds())
+	if err != nil {
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	wm, err := f.watermark(prof, payload, src)
+	if err != nil {
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	if wm != nil && wm.textFile != "" {
+		defer os.Remove(wm.textFile)
+	}
+	var command Command
+	if prof.Packaging == profile.PackagingCMAF {
+		command = cmafCommand(source, outDir, prof, audio, wm)
+	} else {
+		dirs := make([]string, 0, len(prof.Ladder)+len(audio))
+		for _, rung := range prof.Ladder {
+			dirs = append(dirs, rung.Dir())
+		}
+		for i := range audio {
+			dirs = append(dirs, audioDir(i))
+		}
+		for _, dir := range dirs {
+			if err := os.MkdirAll(filepath.Join(outDir, dir), 0o755); err != nil {
+				return f.fail(ctx, payload.MediaID, err.Error(), err)
+			}
+		}
+		command = hlsCommand(source, outDir, prof, audio, wm)
 	}
-	// 使用可选的音频映射（0:a:0?），当源没有音轨时不会报错
-	cmd := exec.CommandContext(ctx, f.binary,
-		"-y", "-i", payload.Source,
-		"-preset", "veryfast",
-		"-map", "0:v:0", "-map", "0:a:0?",
-		"-c:v", "h264", "-c:a", "aac",
-		"-b:v", "4000k",
-		"-f", "hls",
-		"-hls_time", "4",
-		"-hls_playlist_type", "vod",
-		filepath.Join(outDir, "index.m3u8"),
-	)
+	cmd := exec.CommandContext(ctx, f.binary, command.Args()...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	started = time.Now()
+	err = cmd.Run()
+	// 无论成功与否（包括被取消），ffmpeg 实际消耗的时长都计入转码配额
+	_ = f.quota.AddTranscodeSeconds(context.WithoutCancel(ctx), payload.OwnerID, time.Since(started).Seconds())
+	if err != nil {
 		// 标记失败并返回错误（stderr 便于排查）
-		_ = f.repo.UpdateStatus(ctx, payload.MediaID, media.StatusFailed)
-		return fmt.Errorf("ffmpeg 失败: %v, stderr=%s", err, stderr.String())
+		// stderr 末尾通常是真正的错误信息，用作失败原因
+		reason := fmt.Sprintf("ffmpeg 失败: %v: %s", err, tail(stderr.String(), 400))
+		return f.fail(ctx, payload.MediaID, reason, fmt.Errorf("ffmpeg 失败: %v, stderr=%s", err, stderr.String()))
 	}
-	// Expose via backend static path /hls
-	relURL := filepath.ToSlash(filepath.Join("/hls", fmt.Sprintf("media-%d", payload.MediaID), "index.m3u8"))
-	variants := []media.Variant{{Quality: "1080p", Format: "HLS", CDNURL: relURL}}
+	// cmaf 打包时 dash 封装器写出的主播放列表不带音轨语言与 CODECS，同样以此覆盖
+	if err := writeMaster(filepath.Join(outDir, "index.m3u8"), prof, src, audio); err != nil {
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	var artifacts Artifacts
+	if f.artwork != nil {
+		started := time.Now()
+		artifacts = f.artwork.Render(ctx, source, outDir, info)
+		_ = f.quota.AddTranscodeSeconds(context.WithoutCancel(ctx), payload.OwnerID, time.Since(started).Seconds())
+	}
+	prefix := media.OutputPrefix(payload.MediaID)
+	// 重新转码会覆盖同名文件，按写入前后目录总大小的差值计入存储用量
+	err = chargeStored(ctx, f.quota, f.outputs, payload.OwnerID, prefix, func() error {
+		_, err := storage.PutDir(ctx, f.outputs, prefix, outDir)
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("上传转码产物失败: %w", err)
+		return f.fail(ctx, payload.MediaID, err.Error(), err)
+	}
+	variants := append(outputVariants(prefix, prof, src, audio), artworkVariants(prefix, prof, artifacts)...)
 	if err := f.repo.SaveVariants(ctx, payload.MediaID, variants); err != nil {
 		return err
 	}
+	if err := f.repo.SetLoudness(ctx, payload.MediaID, loudness); err != nil {
+		return err
+	}
+	// 字幕需要改写已写入存储的主播放列表，放在 variant 保存之后
+	f.subtitles.Render(ctx, payload.MediaID, payload.OwnerID, source, info)
 	if err := f.repo.UpdateStatus(ctx, payload.MediaID, media.StatusReady); err != nil {
 		return err
 	}
+	f.subtitles.PackagePending(ctx, payload.MediaID)
 	return nil
 }
+
+// watermark 配置了水印时替换文字中的占位符并写入工作目录，未配置时返回 nil
+func (f *FFmpeg) watermark(p profile.Profile, payload queue.JobPayload, src sourceVideo) (*watermark, error) {
+	if p.Watermark == nil {
+		return nil, nil
+	}
+	textFile := ""
+	if text := p.Watermark.ExpandText(payload.OwnerID, payload.MediaID); text != "" {
+		tmp, err := os.CreateTemp(f.workDir, fmt.Sprintf("watermark-%d-*.txt", payload.MediaID))
+		if err != nil {
+			return nil, err
+		}
+		_, err = tmp.WriteString(text)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+		textFile = tmp.Name()
+	}
+	return newWatermark(p.Watermark, textFile, src), nil
+}
+
+// outputVariants 主播放列表在前，前端默认播放第一个 variant 即可自适应码率；
+// 各档附带 CODECS，客户端可据此选择能解码的更省带宽的编码。备选音轨各记录一条 AUDIO variant
+func outputVariants(prefix string, p profile.Profile, src sourceVideo, audio []audioTrack) []media.Variant {
+	variants := []media.Variant{{Quality: "auto", Format: media.FormatHLS, StorageKey: prefix + "/index.m3u8", Profile: p.Name}}
+	if p.Packaging == profile.PackagingCMAF {
+		variants = append(variants, media.Variant{Quality: "auto", Format: media.FormatDASH, StorageKey: prefix + "/" + cmafManifest, Profile: p.Name})
+	}
+	for i, rung := range p.Ladder {
+		key := prefix + "/" + rungURI(p, i)
+		variants = append(variants, media.Variant{
+			Quality:    rung.Name,
+			Format:     media.FormatHLS,
+			Codec:      streamCodecs(p, rung, src, len(audio) > 0),
+			StorageKey: key,
+			Profile:    p.Name,
+		})
+	}
+	for i, t := range audio {
+		variants = append(variants, media.Variant{
+			Quality:    audioQuality(i),
+			Format:     media.FormatAudio,
+			Codec:      audioCodecString(p.Audio.Codec),
+			StorageKey: prefix + "/" + audioURI(p, i),
+			Profile:    p.Name,
+			Language:   t.language,
+			Label:      t.name,
+		})
+	}
+	return variants
+}
+
+func artworkVariants(prefix string, p profile.Profile, a Artifacts) []media.Variant {
+	var variants []media.Variant
+	for _, item := range []struct{ format, path string }{
+		{media.FormatPoster, a.Poster},
+		{media.FormatThumbnails, a.Thumbnails},
+		{media.FormatTrickplay, a.Trickplay},
+		{media.FormatPreview, a.Preview},
+	} {
+		if item.path != "" {
+			variants = append(variants, media.Variant{Quality: "auto", Format: item.format, StorageKey: prefix + "/" + item.path, Profile: p.Name})
+		}
+	}
+	return variants
+}
+
+// hlsCommand 一次解码、每档阶梯各一个只含视频的 HLS 输出（<outDir>/<rung>/index.m3u8），
+// 每条音轨各一个只含音频的输出（<outDir>/audio/<i>/index.m3u8），由主播放列表组合为备选音轨。
+// 各档按分片时长强制关键帧，保证切换码率时分片边界对齐；水印（如有）在拆分各档之前叠加
+func hlsCommand(source, outDir string, p profile.Profile, audio []audioTrack, wm *watermark) Command {
+	segExt := ".ts"
+	muxer := Options{}.
+		Set("hls_time", strconv.Itoa(p.SegmentSeconds)).
+		Set("hls_playlist_type", "vod")
+	if p.Container == profile.ContainerFMP4 {
+		segExt = ".m4s"
+		muxer = muxer.Set("hls_segment_type", "fmp4")
+	}
+	cmd := Command{
+		Global: Options{}.Flag("y"),
+		Inputs: []Input{{Path: source}},
+	}
+	cmd.Filters = append(cmd.Filters, ladderFilters(p, wm.apply(&cmd, "0:v:0"))...)
+	for i, rung := range p.Ladder {
+		dir := filepath.Join(outDir, rung.Dir())
+		cmd.Outputs = append(cmd.Outputs, Output{
+			Maps:     []string{fmt.Sprintf("[v%d]", i)},
+			Encoders: []Encoder{videoEncoder("v", p, rung)},
+			Format:   "hls",
+			Muxer:    muxer.Set("hls_segment_filename", filepath.Join(dir, "seg_%05d"+segExt)),
+			Path:     filepath.Join(dir, "index.m3u8"),
+		})
+	}
+	for i, t := range audio {
+		dir := filepath.Join(outDir, audioDir(i))
+		cmd.Outputs = append(cmd.Outputs, Output{
+			Maps:     []string{fmt.Sprintf("0:%d", t.stream)},
+			Encoders: []Encoder{audioEncoder("a", p.Audio, t.filter)},
+			Format:   "hls",
+			Muxer:    muxer.Set("hls_segment_filename", filepath.Join(dir, "seg_%05d"+segExt)),
+			Path:     filepath.Join(dir, "index.m3u8"),
+		})
+	}
+	return cmd
+}
+
+// scaleFilter 按阶梯高度缩放，保持源分辨率时返回空串
+func scaleFilter(rung profile.Rung) string {
+	if rung.Height <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("scale=-2:%d", rung.Height)
+}
+
+// ladderFilters 把 in 拆分给码率阶梯的各档并按档位高度缩放，第 i 档的输出标签为 v<i>
+func ladderFilters(p profile.Profile, in string) []Filter {
+	split := Filter{Inputs: []string{in}, Expr: fmt.Sprintf("split=%d", len(p.Ladder))}
+	for i := range p.Ladder {
+		split.Outputs = append(split.Outputs, fmt.Sprintf("s%d", i))
+	}
+	filters := []Filter{split}
+	for i, rung := range p.Ladder {
+		expr := scaleFilter(rung)
+		if expr == "" {
+			expr = "null"
+		}
+		filters = append(filters, Filter{Inputs: []string{fmt.Sprintf("s%d", i)}, Expr: expr, Outputs: []string{fmt.Sprintf("v%d", i)}})
+	}
+	return filters
+}
+
+func videoEncoder(stream string, p profile.Profile, rung profile.Rung) Encoder {
+	codec, preset, opts := encoderFor(p, rung)
+	opts = opts.Set("preset", preset).Set("b", kbps(rung.VideoBitrate))
+	if rung.MaxBitrate > 0 {
+		opts = opts.Set("maxrate", kbps(rung.MaxBitrate)).Set("bufsize", kbps(rung.MaxBitrate*2))
+	}
+	opts = opts.Set("force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", p.SegmentSeconds))
+	return Encoder{Stream: stream, Codec: codec, Options: opts}
+}
+
+// audioEncoder filter 为该音轨的下混与响度标准化滤镜链；指定声道布局时由滤镜下混，不再设置 -ac
+func audioEncoder(stream string, a profile.Audio, filter string) Encoder {
+	opts := Options{}.Set("filter", filter)
+	if a.Bitrate > 0 {
+		opts = opts.Set("b", kbps(a.Bitrate))
+	}
+	if a.Channels > 0 && a.ChannelLayout == "" {
+		opts = opts.Set("ac", strconv.Itoa(a.Channels))
+	}
+	if a.SampleRate > 0 {
+		opts = opts.Set("ar", strconv.Itoa(a.SampleRate))
+	}
+	return Encoder{Stream: stream, Codec: a.Codec, Options: opts}
+}
+
+// writeMaster 写入引用各档媒体播放列表的主播放列表，源分辨率已知时附带 RESOLUTION；
+// 音轨登记为 AUDIO 组，第一条为默认音轨
+func writeMaster(path string, p profile.Profile, src sourceVideo, audio []audioTrack) error {
+	version := 3
+	if p.Container == profile.ContainerFMP4 {
+		version = 7
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n", version)
+	for i, t := range audio {
+		b.WriteString(audioRendition(t, i == 0, audioURI(p, i)))
+	}
+	for i, rung := range p.Ladder {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", p.Bandwidth(rung))
+		if w, h := src.size(rung); w > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", w, h)
+		}
+		fmt.Fprintf(&b, ",CODECS=\"%s\"", streamCodecs(p, rung, src, len(audio) > 0))
+		if len(audio) > 0 {
+			fmt.Fprintf(&b, ",AUDIO=\"%s\"", audioGroup)
+		}
+		fmt.Fprintf(&b, "\n%s\n", rungURI(p, i))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// rungURI 第 i 档视频的媒体播放列表，相对于输出目录
+func rungURI(p profile.Profile, i int) string {
+	if p.Packaging == profile.PackagingCMAF {
+		return cmafMediaPlaylist(i)
+	}
+	return p.Ladder[i].Dir() + "/index.m3u8"
+}
+
+// audioURI 第 i 条音轨的媒体播放列表；cmaf 打包时音频输出流排在各档视频之后
+func audioURI(p profile.Profile, i int) string {
+	if p.Packaging == profile.PackagingCMAF {
+		return cmafMediaPlaylist(len(p.Ladder) + i)
+	}
+	return audioDir(i) + "/index.m3u8"
+}
+
+func kbps(n int) string {
+	return strconv.Itoa(n) + "k"
+}
+
+// fail 标记资源失败并返回原错误。作业被取消（进程退出或租约被其他实例接管）时
+// 不改状态，由重新投递或接管方继续处理。
+func (f *FFmpeg) fail(ctx context.Context, mediaID uint, reason string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("作业已取消: %w", err)
+	}
+	_ = f.repo.MarkFailed(ctx, mediaID, reason)
+	return err
+}
+
+// chargeStored 执行 write，并按写入前后输出目录总大小的差值计入存储用量。
+// 任一次统计失败时跳过调整，避免把整个目录误记为新增或释放
+func chargeStored(ctx context.Context, q *quota.Service, outputs storage.Storage, ownerID, prefix string, write func() error) error {
+	before, sizeErr := storedBytes(ctx, outputs, prefix)
+	err := write()
+	if sizeErr != nil {
+		return err
+	}
+	if after, sizeErr := storedBytes(ctx, outputs, prefix); sizeErr == nil {
+		_ = q.AddBytes(ctx, ownerID, after-before)
+	}
+	return err
+}
+
+// storedBytes 输出目录当前的总大小
+func storedBytes(ctx context.Context, outputs storage.Storage, prefix string) (int64, error) {
+	objects, err := outputs.List(ctx, prefix+"/")
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, obj := range objects {
+		total += obj.Size
+	}
+	return total, nil
+}
+
+// tail 取 stderr 末尾约 n 字节用于失败原因
+func tail(s string, n int) string {
+	return textutil.Tail(strings.TrimSpace(s), n)
+}
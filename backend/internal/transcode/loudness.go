@@ -0,0 +1,145 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"parallel/internal/profile"
+)
+
+// loudnormSampleRate loudnorm 内部上采样到 192kHz，输出前需要重采样；配置未指定采样率时使用 48kHz
+const loudnormSampleRate = 48000
+
+// loudnessStats loudnorm 第一遍以 print_format=json 输出的测量值，数值以字符串给出
+type loudnessStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// Integrated 测得的积分响度（LUFS），静音音轨为 -inf 时返回 false
+func (s loudnessStats) Integrated() (float64, bool) {
+	v, err := strconv.ParseFloat(s.InputI, 64)
+	if err != nil || v < -99 {
+		return 0, false
+	}
+	return v, true
+}
+
+// downmixFilter 按配置的声道布局下混，未指定布局时返回空串
+func downmixFilter(a profile.Audio) string {
+	if a.ChannelLayout == "" {
+		return ""
+	}
+	expr := "aresample=ochl=" + a.ChannelLayout
+	if a.CenterMixLevel > 0 {
+		expr += ":clev=" + strconv.FormatFloat(a.CenterMixLevel, 'f', -1, 64)
+	}
+	return expr
+}
+
+// loudnormExpr 第一遍只给出目标参数并输出测量值；第二遍带上测量值以线性增益调整，避免动态压缩
+func loudnormExpr(n profile.Loudnorm, stats *loudnessStats) string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	expr := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", f(n.Integrated), f(n.TruePeak), f(n.Range))
+	if stats == nil {
+		return expr + ":print_format=json"
+	}
+	return expr + fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset)
+}
+
+// audioFilter 音轨的滤镜链：先下混，再按第一遍的测量值做响度标准化；不需要处理时返回空串
+func audioFilter(a profile.Audio, stats *loudnessStats) string {
+	var chain []string
+	if expr := downmixFilter(a); expr != "" {
+		chain = append(chain, expr)
+	}
+	if a.Loudnorm != nil && stats != nil {
+		rate := a.SampleRate
+		if rate == 0 {
+			rate = loudnormSampleRate
+		}
+		chain = append(chain, loudnormExpr(*a.Loudnorm, stats), fmt.Sprintf("aresample=%d", rate))
+	}
+	return strings.Join(chain, ",")
+}
+
+// prepareAudio 为各音轨生成滤镜链。开启响度标准化时逐条测量，静音音轨不做标准化；
+// 返回默认音轨（第一条）测得的积分响度，未测量时为 nil
+func prepareAudio(ctx context.Context, binary, source string, a profile.Audio, tracks []audioTrack) (*float64, error) {
+	var loudness *float64
+	for i := range tracks {
+		if a.Loudnorm == nil {
+			tracks[i].filter = audioFilter(a, nil)
+			continue
+		}
+		stats, err := measureLoudness(ctx, binary, source, tracks[i].stream, a)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := stats.Integrated()
+		if !ok {
+			tracks[i].filter = audioFilter(a, nil)
+			continue
+		}
+		if i == 0 {
+			loudness = &v
+		}
+		tracks[i].filter = audioFilter(a, &stats)
+	}
+	return loudness, nil
+}
+
+// measureLoudness 第一遍：解码源文件中的一条音轨，经过与输出相同的下混后测量响度
+func measureLoudness(ctx context.Context, binary, source string, stream int, a profile.Audio) (loudnessStats, error) {
+	filter := loudnormExpr(*a.Loudnorm, nil)
+	if expr := downmixFilter(a); expr != "" {
+		filter = expr + "," + filter
+	}
+	c := Command{
+		Global: Options{}.Flag("hide_banner").Flag("nostats"),
+		Inputs: []Input{{Path: source}},
+		Outputs: []Output{{
+			Maps:     []string{fmt.Sprintf("0:%d", stream)},
+			Encoders: []Encoder{{Stream: "a", Options: Options{}.Set("filter", filter)}},
+			Options:  Options{}.Flag("vn").Flag("sn"),
+			Format:   "null",
+			Path:     "-",
+		}},
+	}
+	cmd := exec.CommandContext(ctx, binary, c.Args()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return loudnessStats{}, fmt.Errorf("响度测量失败: %v: %s", err, tail(stderr.String(), 400))
+	}
+	return parseLoudnessStats(stderr.String())
+}
+
+// parseLoudnessStats 从 stderr 中取出 loudnorm 在结束时打印的最后一个 JSON 对象
+func parseLoudnessStats(stderr string) (loudnessStats, error) {
+	var stats loudnessStats
+	end := strings.LastIndex(stderr, "}")
+	if end < 0 {
+		return stats, fmt.Errorf("响度测量结果缺失")
+	}
+	start := strings.LastIndex(stderr[:end], "{")
+	if start < 0 {
+		return stats, fmt.Errorf("响度测量结果缺失")
+	}
+	if err := json.Unmarshal([]byte(stderr[start:end+1]), &stats); err != nil {
+		return stats, fmt.Errorf("解析响度测量结果失败: %w", err)
+	}
+	if stats.InputI == "" || stats.TargetOffset == "" {
+		return stats, fmt.Errorf("响度测量结果不完整")
+	}
+	return stats, nil
+}
@@ -0,0 +1,146 @@
+package transcode
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sceneSample 场景变化评分中的一帧
+type sceneSample struct {
+	at    float64
+	score float64
+}
+
+// preview 拼接若干段代表性片段生成静音预览（mp4 或动画 webp），返回相对于输出目录的路径
+func (a *Artwork) preview(ctx context.Context, source, dir string, duration float64) (string, error) {
+	scores, err := a.sceneScores(ctx, source, dir)
+	if err != nil {
+		return "", err
+	}
+	clips := a.opts.PreviewClips
+	clipLen := a.opts.Preview.Seconds() / float64(clips)
+	starts := pickClips(scores, duration, clips, clipLen)
+
+	cmd := Command{Global: Options{}.Flag("y")}
+	concat := Filter{Expr: fmt.Sprintf("concat=n=%d:v=1:a=0", len(starts)), Outputs: []string{"preview"}}
+	for i, start := range starts {
+		cmd.Inputs = append(cmd.Inputs, Input{
+			Options: Options{}.Set("ss", seconds(start)).Set("t", seconds(clipLen)),
+			Path:    source,
+		})
+		label := fmt.Sprintf("c%d", i)
+		cmd.Filters = append(cmd.Filters, Filter{
+			Inputs:  []string{fmt.Sprintf("%d:v:0", i)},
+			Expr:    fmt.Sprintf("scale=-2:%d,fps=12,setsar=1,setpts=PTS-STARTPTS", a.opts.PreviewHeight),
+			Outputs: []string{label},
+		})
+		concat.Inputs = append(concat.Inputs, label)
+	}
+	cmd.Filters = append(cmd.Filters, concat)
+
+	name := "preview." + a.opts.PreviewFormat
+	out := Output{Maps: []string{"[preview]"}, Options: Options{}.Flag("an"), Path: filepath.Join(dir, name)}
+	if a.opts.PreviewFormat == "webp" {
+		out.Encoders = []Encoder{{Stream: "v", Codec: "libwebp", Options: Options{}.Set("q", "60")}}
+		out.Muxer = Options{}.Set("loop", "0")
+	} else {
+		out.Encoders = []Encoder{{Stream: "v", Codec: "libx264", Options: Options{}.Set("crf", "28").Set("pix_fmt", "yuv420p")}}
+		out.Muxer = Options{}.Set("movflags", "+faststart")
+	}
+	cmd.Outputs = []Output{out}
+	if err := runFFmpeg(ctx, a.binary, cmd); err != nil {
+		return "", err
+	}
+	return artworkDir + "/" + name, nil
+}
+
+// sceneScores 以低分辨率、低帧率解码整段视频，记录每帧相对前一帧的场景变化评分
+func (a *Artwork) sceneScores(ctx context.Context, source, dir string) ([]sceneSample, error) {
+	path := filepath.Join(dir, "scenes.txt")
+	defer os.Remove(path)
+	cmd := Command{
+		Global: Options{}.Flag("y"),
+		Inputs: []Input{{Path: source}},
+		Outputs: []Output{{
+			Maps: []string{"0:v:0"},
+			Encoders: []Encoder{{Stream: "v", Options: Options{}.Set("filter",
+				"fps=2,scale=160:-2,select='gte(scene,0)',metadata=print:key=lavfi.scene_score:file="+path)}},
+			Options: Options{}.Flag("an"),
+			Format:  "null",
+			Path:    "-",
+		}},
+	}
+	if err := runFFmpeg(ctx, a.binary, cmd); err != nil {
+		return nil, err
+	}
+	return parseSceneScores(path)
+}
+
+// parseSceneScores 解析 metadata=print 的输出：
+//
+//	frame:12   pts:6      pts_time:6
+//	lavfi.scene_score=0.412000
+func parseSceneScores(path string) ([]sceneSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []sceneSample
+	at := -1.0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "frame:") {
+			at = -1
+			if i := strings.Index(line, "pts_time:"); i >= 0 {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(line[i+len("pts_time:"):]), 64); err == nil {
+					at = v
+				}
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "lavfi.scene_score="); ok && at >= 0 {
+			if score, err := strconv.ParseFloat(v, 64); err == nil {
+				out = append(out, sceneSample{at: at, score: score})
+			}
+		}
+	}
+	return out, sc.Err()
+}
+
+// pickClips 去掉首尾各 5% 后把时长均分为 clips 段，每段取场景变化评分最高的时刻作为片段起点，
+// 没有评分时取该段中点；时长不足时只取开头一段
+func pickClips(scores []sceneSample, duration float64, clips int, clipLen float64) []float64 {
+	if duration <= clipLen*float64(clips) {
+		return []float64{0}
+	}
+	from, to := duration*0.05, duration*0.95-clipLen
+	window := (to - from) / float64(clips)
+	if window <= 0 {
+		return []float64{0}
+	}
+	starts := make([]float64, 0, clips)
+	for i := 0; i < clips; i++ {
+		lo, hi := from+float64(i)*window, from+float64(i+1)*window
+		best, bestScore := (lo+hi)/2, -1.0
+		for _, s := range scores {
+			if s.at >= lo && s.at < hi && s.score > bestScore {
+				best, bestScore = s.at, s.score
+			}
+		}
+		starts = append(starts, best)
+	}
+	sort.Float64s(starts)
+	return starts
+}
+
+func seconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -1,168 +1,396 @@
 package transcode
 
 import (
-    "context"
-    "encoding/json"
-    "log"
-    "strings"
-    "sync"
-    "time"
-
-	"github.com/redis/go-redis/v9"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
 
 	"parallel/internal/queue"
 )
 
 type Scheduler struct {
-    dispatcher *queue.Dispatcher
-    worker     Worker
-    logger     *log.Logger
+	queue  queue.Queue
+	worker Worker
+	logger *log.Logger
+	opts   SchedulerOptions
 
-    groupName string
-    consumer  string
+	groupName string
+	consumer  string
+	picker    *queue.Picker
+	fair      *fairQueue
 	once      sync.Once
 }
 
 type Worker interface {
 	Process(ctx context.Context, payload queue.JobPayload) error
+	// Reject 作业未被准入时调用，负责把资源标记为失败
+	Reject(ctx context.Context, payload queue.JobPayload, reason error)
+}
+
+// Admission 在作业执行前做准入校验（如转码配额），返回错误则作业不会执行
+type Admission interface {
+	Admit(ctx context.Context, payload queue.JobPayload) error
 }
 
-func NewScheduler(dispatcher *queue.Dispatcher, worker Worker, logger *log.Logger) *Scheduler {
-    return &Scheduler{
-        dispatcher: dispatcher,
-        worker:     worker,
-        logger:     logger,
-        groupName:  "transcode_group",
-        // 使用较稳定的 consumer 名称，避免重启后大量消息留在旧 consumer 的 PEL
-        // 仍然配合 XAUTOCLAIM 做兜底认领
-        consumer:   "consumer-main",
-    }
+// JobTracker 记录作业执行与心跳，供卡住作业巡检判断 worker 是否存活，
+// 并据幂等 key 识别重复投递
+type JobTracker interface {
+	IsDuplicate(ctx context.Context, key string, liveWithin time.Duration) (bool, error)
+	StartJob(ctx context.Context, payload queue.JobPayload, messageID, consumer string) (uint, error)
+	HeartbeatJob(ctx context.Context, jobID uint) error
+	FinishJob(ctx context.Context, jobID uint, jobErr error) error
+}
+
+// SchedulerOptions 调度器的可选配置，零值即默认行为
+type SchedulerOptions struct {
+	Admission Admission
+	Tracker   JobTracker
+	// Consumer 消费者名称，多实例部署时必须互不相同，默认取主机名
+	Consumer string
+	// LeaseTTL 作业租约时长：执行中的作业会定期续约，
+	// 空闲超过该时长的 pending 消息才会被其他实例认领。默认 2m
+	LeaseTTL time.Duration
+	// HeartbeatInterval 心跳与续约间隔，默认 LeaseTTL/3
+	HeartbeatInterval time.Duration
+	// Weights 各优先级 Stream 的读取权重，缺省时各优先级权重相同
+	Weights map[queue.Priority]int
+	// Concurrency 本实例同时执行的作业数，默认 1
+	Concurrency int
+	// MaxPerOwner 本实例内单个 owner 同时执行的作业数上限，0 表示不限
+	MaxPerOwner int
+	// Prefetch 预先领取并按 owner 轮询的作业数上限，默认 Concurrency*8。
+	// 取值越大越能越过单个用户的批量提交找到其他用户的作业，但占用的租约也越多
+	Prefetch int
+}
+
+var (
+	// errLeaseLost 租约已被其他实例认领，本实例放弃执行且不 ACK
+	errLeaseLost = errors.New("lease lost")
+	// errRetry 作业尚未开始执行即遇到队列错误，Nack 后交由下一次认领重试
+	errRetry = errors.New("retry later")
+)
+
+func NewScheduler(q queue.Queue, worker Worker, logger *log.Logger, opts SchedulerOptions) *Scheduler {
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = 2 * time.Minute
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = opts.LeaseTTL / 3
+	}
+	if opts.Consumer == "" {
+		opts.Consumer = defaultConsumer()
+	}
+	if opts.Weights == nil {
+		opts.Weights, _ = queue.ParseWeights("")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = opts.Concurrency * 8
+	}
+	return &Scheduler{
+		queue:     q,
+		worker:    worker,
+		logger:    logger,
+		opts:      opts,
+		groupName: "transcode_group",
+		// 每个实例使用各自稳定的 consumer 名称，续约时据此判断租约归属；
+		// 重启后遗留在旧 consumer PEL 中的消息由 XAUTOCLAIM 兜底认领
+		consumer: opts.Consumer,
+		picker:   queue.NewPicker(opts.Weights),
+		fair:     newFairQueue(opts.MaxPerOwner),
+	}
+}
+
+func defaultConsumer() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "consumer-main"
+	}
+	return "consumer-" + host
+}
+
+// Group 返回调度器使用的消费组名
+func (s *Scheduler) Group() string {
+	return s.groupName
 }
 
 func (s *Scheduler) Start(ctx context.Context) error {
 	var startErr error
 	s.once.Do(func() {
-		if err := s.ensureGroup(ctx); err != nil {
+		if err := s.queue.Setup(ctx, s.groupName); err != nil {
 			startErr = err
 			return
 		}
+		go func() {
+			<-ctx.Done()
+			s.fair.close()
+			// 退出时放弃缓冲中的作业，其他实例无需等待租约过期即可认领
+			for _, j := range s.fair.buffered() {
+				s.nack(context.Background(), j.msg)
+			}
+		}()
+		for i := 0; i < s.opts.Concurrency; i++ {
+			go s.work(ctx)
+		}
+		go s.keepBuffered(ctx)
 		go s.loop(ctx)
 	})
 	return startErr
 }
 
-func (s *Scheduler) ensureGroup(ctx context.Context) error {
-	client := s.dispatcher.Client()
-	if err := client.XGroupCreateMkStream(ctx, s.dispatcher.Stream(), s.groupName, "0").Err(); err != nil {
-		if !isGroupExistsErr(err) {
-			return err
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		// 缓冲已满时暂停领取，已领取的消息由 keepBuffered 续约
+		if s.fair.len() >= s.opts.Prefetch {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+			continue
+		}
+		// 先认领陈旧 pending 消息，避免消息永远卡在旧 consumer 的 PEL
+		if err := s.claimAndProcessPending(ctx); err != nil {
+			s.logger.Printf("claim pending error: %v", err)
 		}
+
+		// 再读取新消息
+		messages, err := s.next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Printf("consume error: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		s.processMessages(ctx, messages)
 	}
-	return nil
 }
 
-func isGroupExistsErr(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "BUSYGROUP")
+// next 按加权轮询决定本轮优先读取的队列，轮到的队列为空时依次降级到其他队列；
+// 全部为空时阻塞等待任一队列的新消息
+func (s *Scheduler) next(ctx context.Context) ([]queue.Message, error) {
+	for _, p := range s.picker.Order() {
+		messages, err := s.queue.Consume(ctx, s.groupName, s.consumer, []queue.Priority{p}, -1)
+		if err != nil || len(messages) > 0 {
+			return messages, err
+		}
+	}
+	return s.queue.Consume(ctx, s.groupName, s.consumer, queue.Priorities, 5*time.Second)
 }
 
-func (s *Scheduler) loop(ctx context.Context) {
-    for {
-        select {
-        case <-ctx.Done():
-            return
-        default:
-        }
-        // 先认领陈旧 pending 消息，避免消息永远卡在旧 consumer 的 PEL
-        if err := s.claimAndProcessPending(ctx); err != nil {
-            s.logger.Printf("claim pending error: %v", err)
-        }
-
-        // 再读取新消息
-        messages, err := s.dispatcher.Consume(ctx, s.groupName, s.consumer)
-        if err != nil {
-            if err != redis.Nil {
-                s.logger.Printf("consume error: %v", err)
-            }
-            continue
-        }
-        s.processMessages(ctx, messages)
-    }
-}
-
-func (s *Scheduler) Submit(ctx context.Context, payload queue.JobPayload) error {
-    return s.dispatcher.EnqueueJob(ctx, payload)
-}
-
-// claimAndProcessPending 使用 XAUTOCLAIM 认领空闲超过阈值的 pending 消息
+func (s *Scheduler) Submit(ctx context.Context, payload queue.JobPayload) (string, error) {
+	return s.queue.Enqueue(ctx, payload)
+}
+
+// claimAndProcessPending 认领空闲超过租约时长的 pending 消息。
+// 执行中与缓冲中的作业会持续续约，因此能被认领的只有 worker 已失联的作业。
 func (s *Scheduler) claimAndProcessPending(ctx context.Context) error {
-    client := s.dispatcher.Client()
-    // 认领空闲 >=30s 的消息，分批次处理
-    const minIdle = 30 * time.Second
-    start := "0-0"
-    for i := 0; i < 10; i++ { // 最多循环 10 次，避免长时间阻塞
-        msgs, next, err := client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
-            Stream:   s.dispatcher.Stream(),
-            Group:    s.groupName,
-            Consumer: s.consumer,
-            MinIdle:  minIdle,
-            Start:    start,
-            Count:    20,
-        }).Result()
-        if err != nil {
-            if err == redis.Nil {
-                return nil
-            }
-            return err
-        }
-        if len(msgs) == 0 {
-            return nil
-        }
-        s.processMessages(ctx, msgs)
-        start = next
-    }
-    return nil
-}
-
-// processMessages 统一处理消息（解析、执行业务、ACK）
-func (s *Scheduler) processMessages(ctx context.Context, messages []redis.XMessage) {
-    for _, msg := range messages {
-        rawValue, ok := msg.Values["payload"]
-        if !ok {
-            s.logger.Printf("payload 缺失: %+v", msg.Values)
-            _ = s.dispatcher.Ack(ctx, s.groupName, msg.ID)
-            continue
-        }
-
-        var payloadBytes []byte
-        switch v := rawValue.(type) {
-        case string:
-            payloadBytes = []byte(v)
-        case []byte:
-            payloadBytes = v
-        default:
-            s.logger.Printf("payload 类型错误: %T", rawValue)
-            _ = s.dispatcher.Ack(ctx, s.groupName, msg.ID)
-            continue
-        }
-
-        var payload queue.JobPayload
-        if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-            s.logger.Printf("payload 解析失败: %v", err)
-            _ = s.dispatcher.Ack(ctx, s.groupName, msg.ID)
-            continue
-        }
-
-        if err := s.worker.Process(ctx, payload); err != nil {
-            s.logger.Printf("process job %s error: %v", msg.ID, err)
-            // 处理失败：直接 ACK 避免作业卡在 pending；状态已在 worker 内标记为 FAILED
-            if ackErr := s.dispatcher.Ack(ctx, s.groupName, msg.ID); ackErr != nil {
-                s.logger.Printf("ack failed job %s error: %v", msg.ID, ackErr)
-            }
-            continue
-        }
-
-        if err := s.dispatcher.Ack(ctx, s.groupName, msg.ID); err != nil {
-            s.logger.Printf("ack job %s error: %v", msg.ID, err)
-        }
-    }
+	for _, p := range queue.Priorities {
+		for i := 0; i < 10; i++ { // 每个队列最多认领 10 条，避免长时间占用读取循环
+			messages, err := s.queue.Claim(ctx, s.groupName, s.consumer, p, s.opts.LeaseTTL, 1)
+			if err != nil {
+				return err
+			}
+			if len(messages) == 0 {
+				break
+			}
+			s.processMessages(ctx, messages)
+		}
+	}
+	return nil
+}
+
+// processMessages 解析消息并放入按 owner 分桶的缓冲队列。
+// 无法解析或类型未知的消息转入死信队列，便于新版本上线后重新投递
+func (s *Scheduler) processMessages(ctx context.Context, messages []queue.Message) {
+	for _, msg := range messages {
+		payload, err := queue.Decode(msg.Body)
+		if err != nil {
+			s.logger.Printf("dead-letter message %s: %v", msg.ID, err)
+			if err := s.queue.DeadLetter(ctx, s.groupName, msg, err.Error()); err != nil {
+				s.logger.Printf("dead-letter message %s error: %v", msg.ID, err)
+			}
+			continue
+		}
+		s.fair.push(&pendingJob{msg: msg, payload: payload})
+	}
+}
+
+// work 从缓冲队列按 owner 轮询取出作业执行，直到队列关闭
+func (s *Scheduler) work(ctx context.Context) {
+	for {
+		j := s.fair.pop()
+		if j == nil {
+			return
+		}
+		s.execute(ctx, j.msg, j.payload)
+		s.fair.done(j.payload.OwnerID)
+	}
+}
+
+// execute 执行单个作业（去重、准入、转码）并 ACK
+func (s *Scheduler) execute(ctx context.Context, msg queue.Message, payload queue.JobPayload) {
+	if s.isDuplicate(ctx, msg.ID, payload) {
+		if err := s.queue.Ack(ctx, s.groupName, msg); err != nil {
+			s.logger.Printf("ack duplicate job %s error: %v", msg.ID, err)
+		}
+		return
+	}
+
+	if s.opts.Admission != nil {
+		if err := s.opts.Admission.Admit(ctx, payload); err != nil {
+			s.logger.Printf("job %s rejected: %v", msg.ID, err)
+			s.worker.Reject(ctx, payload, err)
+			if ackErr := s.queue.Ack(ctx, s.groupName, msg); ackErr != nil {
+				s.logger.Printf("ack rejected job %s error: %v", msg.ID, ackErr)
+			}
+			return
+		}
+	}
+
+	if err := s.run(ctx, msg, payload); err != nil {
+		if errors.Is(err, errLeaseLost) {
+			s.logger.Printf("job %s lease lost, leaving it to the new owner", msg.ID)
+			return
+		}
+		if errors.Is(err, errRetry) {
+			s.logger.Printf("job %s not started: %v", msg.ID, err)
+			s.nack(ctx, msg)
+			return
+		}
+		s.logger.Printf("process job %s error: %v", msg.ID, err)
+		// 处理失败：直接 ACK 避免作业卡在 pending；状态已在 worker 内标记为 FAILED
+		if ackErr := s.queue.Ack(ctx, s.groupName, msg); ackErr != nil {
+			s.logger.Printf("ack failed job %s error: %v", msg.ID, ackErr)
+		}
+		return
+	}
+
+	if err := s.queue.Ack(ctx, s.groupName, msg); err != nil {
+		s.logger.Printf("ack job %s error: %v", msg.ID, err)
+	}
+}
+
+func (s *Scheduler) nack(ctx context.Context, msg queue.Message) {
+	if err := s.queue.Nack(ctx, s.groupName, s.consumer, msg); err != nil {
+		s.logger.Printf("nack job %s error: %v", msg.ID, err)
+	}
+}
+
+// keepBuffered 为缓冲中尚未执行的作业续约，避免排队期间被其他实例认领；
+// 租约已丢失的作业从缓冲中移除，由新的持有者执行
+func (s *Scheduler) keepBuffered(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, j := range s.fair.buffered() {
+			owned, err := s.queue.Extend(ctx, s.groupName, s.consumer, j.msg)
+			if err != nil {
+				s.logger.Printf("extend buffered lease %s error: %v", j.msg.ID, err)
+				continue
+			}
+			if !owned {
+				s.logger.Printf("buffered job %s lease lost, dropping it", j.msg.ID)
+				s.fair.remove(j)
+			}
+		}
+	}
+}
+
+// isDuplicate 同一幂等 key 的作业已完成或正由其他 worker 执行时返回 true，直接 ACK 不再转码
+func (s *Scheduler) isDuplicate(ctx context.Context, messageID string, payload queue.JobPayload) bool {
+	if s.opts.Tracker == nil || payload.IdempotencyKey == "" {
+		return false
+	}
+	dup, err := s.opts.Tracker.IsDuplicate(ctx, payload.IdempotencyKey, s.opts.LeaseTTL)
+	if err != nil {
+		// 查询失败时按非重复处理：重复转码的代价远小于丢作业
+		s.logger.Printf("check duplicate job %s error: %v", messageID, err)
+		return false
+	}
+	if dup {
+		s.logger.Printf("job %s (key %s) already completed or running elsewhere, skipping", messageID, payload.IdempotencyKey)
+	}
+	return dup
+}
+
+// run 执行作业，期间按 HeartbeatInterval 刷新心跳并续约；
+// 续约发现消息已被其他实例认领时取消本地执行，避免同一作业被转码两次
+func (s *Scheduler) run(ctx context.Context, msg queue.Message, payload queue.JobPayload) error {
+	messageID := msg.ID
+	owned, err := s.queue.Extend(ctx, s.groupName, s.consumer, msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRetry, err)
+	}
+	if !owned {
+		return errLeaseLost
+	}
+
+	var jobID uint
+	if s.opts.Tracker != nil {
+		if jobID, err = s.opts.Tracker.StartJob(ctx, payload, messageID, s.consumer); err != nil {
+			// 记录失败不影响转码本身，只是巡检会缺少心跳信息
+			s.logger.Printf("start job record %s error: %v", messageID, err)
+		}
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lost bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.opts.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			owned, err := s.queue.Extend(ctx, s.groupName, s.consumer, msg)
+			if err != nil {
+				// Redis 短暂不可用时继续执行，下次心跳再续约
+				s.logger.Printf("extend lease %s error: %v", messageID, err)
+			} else if !owned {
+				lost = true
+				cancel()
+				return
+			}
+			if jobID != 0 {
+				if err := s.opts.Tracker.HeartbeatJob(ctx, jobID); err != nil {
+					s.logger.Printf("heartbeat job %d error: %v", jobID, err)
+				}
+			}
+		}
+	}()
+	procErr := s.worker.Process(jobCtx, payload)
+	cancel()
+	<-done
+	if lost {
+		procErr = errLeaseLost
+	}
+	if jobID != 0 {
+		if err := s.opts.Tracker.FinishJob(ctx, jobID, procErr); err != nil {
+			s.logger.Printf("finish job record %d error: %v", jobID, err)
+		}
+	}
+	return procErr
 }
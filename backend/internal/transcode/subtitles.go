@@ -0,0 +1,409 @@
+package transcode
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"parallel/internal/media"
+	"parallel/internal/probe"
+	"parallel/internal/profile"
+	"parallel/internal/quota"
+	"parallel/internal/storage"
+	"parallel/internal/store"
+)
+
+// subtitleGroup 主播放列表中字幕轨所在的 GROUP-ID
+const subtitleGroup = "subs"
+
+// textSubtitleCodecs 可转换为 WebVTT 的文本字幕编码，图形字幕（PGS、DVB）需要 OCR，不做处理
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// Subtitles 字幕阶段：从源文件提取内嵌文本字幕，把全部字幕轨转换为按视频分片时长切分的 WebVTT，
+// 写入 subs/<id>/ 并在主播放列表中登记为 SUBTITLES 组。上传字幕时也会单独调用
+type Subtitles struct {
+	binary   string
+	workDir  string
+	sources  storage.Storage
+	outputs  storage.Storage
+	repo     *media.Repository
+	quota    *quota.Service
+	profiles *profile.Registry
+	logger   *log.Logger
+}
+
+func NewSubtitles(binary, workDir string, sources, outputs storage.Storage, repo *media.Repository, quota *quota.Service, profiles *profile.Registry, logger *log.Logger) *Subtitles {
+	return &Subtitles{binary: binary, workDir: workDir, sources: sources, outputs: outputs, repo: repo, quota: quota, profiles: profiles, logger: logger}
+}
+
+// SubtitleDir 字幕轨在输出目录中的子目录
+func SubtitleDir(id uint) string {
+	return fmt.Sprintf("subs/%d", id)
+}
+
+// Render 转码完成后提取内嵌字幕并打包全部字幕轨（包括此前上传的字幕），失败只记录日志，不影响视频可播放
+func (s *Subtitles) Render(ctx context.Context, mediaID uint, ownerID, source string, info *probe.Result) {
+	s.Extract(ctx, mediaID, ownerID, source, info)
+	if err := s.PackageSubtitles(ctx, mediaID); err != nil {
+		s.logger.Printf("subtitles package media=%d: %v", mediaID, err)
+	}
+}
+
+// Extract 一次解码把源文件中的文本字幕流各自转换为 WebVTT 保存到上传存储，
+// key 按流序号固定，重新转码时覆盖同一文件与记录
+func (s *Subtitles) Extract(ctx context.Context, mediaID uint, ownerID, source string, info *probe.Result) {
+	var streams []probe.Stream
+	for _, st := range info.StreamsOf("subtitle") {
+		if textSubtitleCodecs[st.CodecName] {
+			streams = append(streams, st)
+		}
+	}
+	if len(streams) == 0 {
+		return
+	}
+	dir, err := os.MkdirTemp(s.workDir, fmt.Sprintf("subs-%d-", mediaID))
+	if err != nil {
+		s.logger.Printf("subtitles extract media=%d: %v", mediaID, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	cmd := Command{Global: Options{}.Flag("y"), Inputs: []Input{{Path: source}}}
+	for _, st := range streams {
+		cmd.Outputs = append(cmd.Outputs, Output{
+			Maps:     []string{fmt.Sprintf("0:%d", st.Index)},
+			Encoders: []Encoder{{Stream: "s", Codec: "webvtt"}},
+			Format:   "webvtt",
+			Path:     filepath.Join(dir, fmt.Sprintf("%d.vtt", st.Index)),
+		})
+	}
+	if err := runFFmpeg(ctx, s.binary, cmd); err != nil {
+		s.logger.Printf("subtitles extract media=%d: %v", mediaID, err)
+		return
+	}
+	for _, st := range streams {
+		key := fmt.Sprintf("subtitle-%d-s%d.vtt", mediaID, st.Index)
+		// 重新转码覆盖同一 key，只计入与旧文件的差值
+		var old int64
+		if prev, err := s.sources.Stat(ctx, key); err == nil {
+			old = prev.Size
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Printf("subtitles extract media=%d stream=%d: %v", mediaID, st.Index, err)
+			continue
+		}
+		size, err := storage.PutFile(ctx, s.sources, key, filepath.Join(dir, fmt.Sprintf("%d.vtt", st.Index)))
+		if err != nil {
+			s.logger.Printf("subtitles extract media=%d stream=%d: %v", mediaID, st.Index, err)
+			continue
+		}
+		_ = s.quota.AddBytes(ctx, ownerID, size-old)
+		lang := st.Tags["language"]
+		if lang == "" {
+			lang = "und"
+		}
+		label := st.Tags["title"]
+		if label == "" {
+			label = lang
+		}
+		index := st.Index
+		sub := &store.MediaSubtitle{MediaID: mediaID, Language: lang, Label: label, Origin: store.SubtitleEmbedded, StreamIndex: &index, SourceKey: key}
+		if err := s.repo.UpsertEmbeddedSubtitle(ctx, sub); err != nil {
+			s.logger.Printf("subtitles extract media=%d stream=%d: %v", mediaID, st.Index, err)
+		}
+	}
+}
+
+// PackageSubtitles 重新打包资源的全部字幕轨并改写主播放列表，主播放列表尚未生成时返回错误。
+// 单条字幕转换失败只跳过该轨：输出未被改动且之前打包过的沿用旧结果，
+// 写入了部分文件的删除其目录并清空记录，主播放列表只登记完整的字幕轨
+func (s *Subtitles) PackageSubtitles(ctx context.Context, mediaID uint) error {
+	asset, err := s.repo.GetAsset(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	subs, err := s.repo.ListSubtitles(ctx, mediaID)
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+	prof, err := s.profiles.Get(asset.Profile)
+	if err != nil {
+		return err
+	}
+	prefix := media.OutputPrefix(mediaID)
+	failed := 0
+	err = chargeStored(ctx, s.quota, s.outputs, asset.OwnerID, prefix, func() error {
+		var packaged []store.MediaSubtitle
+		for _, sub := range subs {
+			dir := prefix + "/" + SubtitleDir(sub.ID)
+			written, err := s.packageOne(ctx, dir, sub, asset.Duration, prof)
+			if err != nil {
+				failed++
+				s.logger.Printf("subtitles package media=%d subtitle=%d: %v", mediaID, sub.ID, err)
+				if !written {
+					if sub.StorageKey != "" {
+						packaged = append(packaged, sub)
+					}
+					continue
+				}
+				if _, err := storage.DeletePrefix(ctx, s.outputs, dir+"/"); err != nil {
+					return err
+				}
+				if err := s.repo.SetSubtitleStorageKey(ctx, sub.ID, ""); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.repo.SetSubtitleStorageKey(ctx, sub.ID, dir+"/index.m3u8"); err != nil {
+				return err
+			}
+			packaged = append(packaged, sub)
+		}
+		return s.rewriteMaster(ctx, prefix+"/index.m3u8", packaged)
+	})
+	if err != nil {
+		return err
+	}
+	s.repo.InvalidateSubtitles(ctx, mediaID)
+	if failed > 0 {
+		return fmt.Errorf("%d 条字幕打包失败", failed)
+	}
+	return nil
+}
+
+// PackagePending 资源就绪后补打包尚未打包的字幕轨。转码期间上传的字幕可能晚于 Render 读取字幕列表，
+// 而上传接口读到的状态又尚未就绪，两边都不打包时由这里兜底
+func (s *Subtitles) PackagePending(ctx context.Context, mediaID uint) {
+	subs, err := s.repo.ListSubtitles(ctx, mediaID)
+	if err != nil {
+		s.logger.Printf("subtitles package media=%d: %v", mediaID, err)
+		return
+	}
+	for _, sub := range subs {
+		if sub.StorageKey != "" {
+			continue
+		}
+		if err := s.PackageSubtitles(ctx, mediaID); err != nil {
+			s.logger.Printf("subtitles package media=%d: %v", mediaID, err)
+		}
+		return
+	}
+}
+
+// packageOne 统一转换为 WebVTT（兼容 srt / ass 上传），再按分片时长切分后写入 dir。
+// written 表示失败前是否已开始改写输出存储中的文件
+func (s *Subtitles) packageOne(ctx context.Context, dir string, sub store.MediaSubtitle, duration float64, p profile.Profile) (written bool, err error) {
+	source, cleanup, err := storage.LocalCopy(ctx, s.sources, sub.SourceKey, s.workDir)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+	local, err := os.MkdirTemp(s.workDir, fmt.Sprintf("subtitle-%d-", sub.ID))
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(local)
+	full := filepath.Join(local, "full.vtt")
+	err = runFFmpeg(ctx, s.binary, Command{
+		Global:  Options{}.Flag("y"),
+		Inputs:  []Input{{Path: source}},
+		Outputs: []Output{{Maps: []string{"0:s:0"}, Encoders: []Encoder{{Stream: "s", Codec: "webvtt"}}, Format: "webvtt", Path: full}},
+	})
+	if err != nil {
+		return false, err
+	}
+	cues, err := parseVTT(full)
+	if err != nil {
+		return false, err
+	}
+	if duration <= 0 && len(cues) > 0 {
+		duration = cues[len(cues)-1].end
+	}
+	// ts 分片的时间戳从 1.4 秒（MPEGTS 126000）开始，需要映射后才能与视频对齐；fMP4 从 0 开始
+	timestampMap := p.Container == profile.ContainerTS
+	if err := writeSegmentedVTT(local, cues, duration, p.SegmentSeconds, timestampMap); err != nil {
+		return false, err
+	}
+	_, err = storage.PutDir(ctx, s.outputs, dir, local)
+	return err != nil, err
+}
+
+// rewriteMaster 去掉主播放列表中已有的字幕组后按 subs 重新登记，subs 为空时只做清理
+func (s *Subtitles) rewriteMaster(ctx context.Context, key string, subs []store.MediaSubtitle) error {
+	rc, err := s.outputs.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("读取主播放列表失败: %w", err)
+	}
+	var lines []string
+	sc := bufio.NewScanner(rc)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	rc.Close()
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	out := withSubtitles(lines, subs)
+	return s.outputs.Put(ctx, key, strings.NewReader(out), int64(len(out)), storage.ContentType(key))
+}
+
+var subtitlesAttr = regexp.MustCompile(`,SUBTITLES="[^"]*"`)
+
+// withSubtitles 在第一条 EXT-X-STREAM-INF 之前插入各字幕轨的 EXT-X-MEDIA，
+// 并为每条 STREAM-INF 声明 SUBTITLES 组。同组内 NAME 必须唯一，重名时追加序号
+func withSubtitles(lines []string, subs []store.MediaSubtitle) string {
+	var b strings.Builder
+	inserted := false
+	names := map[string]int{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-MEDIA:") && strings.Contains(line, "TYPE=SUBTITLES") {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if !inserted {
+				for _, sub := range subs {
+					name := quoteless(sub.Label)
+					if names[name]++; names[name] > 1 {
+						name = fmt.Sprintf("%s (%d)", name, names[name])
+					}
+					fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=NO,AUTOSELECT=YES,URI=\"%s/index.m3u8\"\n",
+						subtitleGroup, name, quoteless(sub.Language), SubtitleDir(sub.ID))
+				}
+				inserted = true
+			}
+			line = subtitlesAttr.ReplaceAllString(line, "")
+			if len(subs) > 0 {
+				line += fmt.Sprintf(",SUBTITLES=\"%s\"", subtitleGroup)
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// quoteless 属性值中不允许出现双引号与换行
+func quoteless(s string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(s)
+}
+
+// vttCue 一条字幕，text 保留时间行之后的原始内容
+type vttCue struct {
+	start, end float64
+	settings   string
+	text       string
+}
+
+// parseVTT 读取 ffmpeg 输出的 WebVTT，跳过文件头、NOTE、STYLE 与 REGION 块
+func parseVTT(path string) ([]vttCue, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cues []vttCue
+	blocks := strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n\n")
+	for _, block := range blocks {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			cue, ok := parseCueTiming(line)
+			if ok {
+				cue.text = strings.Join(lines[i+1:], "\n")
+				cues = append(cues, cue)
+			}
+			break
+		}
+	}
+	return cues, nil
+}
+
+// parseCueTiming 解析 "00:01.000 --> 00:02.500 align:start" 形式的时间行
+func parseCueTiming(line string) (vttCue, bool) {
+	left, right, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(right)
+	if len(fields) == 0 {
+		return vttCue{}, false
+	}
+	start, ok1 := parseVTTTime(strings.TrimSpace(left))
+	end, ok2 := parseVTTTime(fields[0])
+	if !ok1 || !ok2 || end <= start {
+		return vttCue{}, false
+	}
+	return vttCue{start: start, end: end, settings: strings.Join(fields[1:], " ")}, true
+}
+
+// parseVTTTime 支持 HH:MM:SS.mmm 与 MM:SS.mmm
+func parseVTTTime(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var total float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		total = total*60 + v
+	}
+	return total, true
+}
+
+// writeSegmentedVTT 写出完整的 full.vtt、按 segment 秒切分的 seg_%05d.vtt 与媒体播放列表 index.m3u8。
+// 跨越分片边界的字幕在每个相关分片中重复出现，播放器按时间去重
+func writeSegmentedVTT(dir string, cues []vttCue, duration float64, segment int, timestampMap bool) error {
+	step := float64(segment)
+	count := int(math.Ceil(duration / step))
+	if count == 0 {
+		count = 1
+	}
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", segment)
+	for i := 0; i < count; i++ {
+		from, to := float64(i)*step, float64(i+1)*step
+		if i == count-1 && duration > from {
+			to = duration
+		}
+		var b strings.Builder
+		b.WriteString("WEBVTT\n")
+		if timestampMap {
+			b.WriteString("X-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n")
+		}
+		for _, cue := range cues {
+			if cue.end <= from || cue.start >= to {
+				continue
+			}
+			writeCue(&b, cue)
+		}
+		name := fmt.Sprintf("seg_%05d.vtt", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", to-from, name)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(playlist.String()), 0o644)
+}
+
+func writeCue(b *strings.Builder, cue vttCue) {
+	fmt.Fprintf(b, "\n%s --> %s", vttTime(cue.start), vttTime(cue.end))
+	if cue.settings != "" {
+		b.WriteString(" " + cue.settings)
+	}
+	b.WriteString("\n" + cue.text + "\n")
+}
@@ -0,0 +1,166 @@
+package transcode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"parallel/internal/store"
+)
+
+func TestParseVTTTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"00:01.500", 1.5, true},
+		{"01:02:03.250", 3723.25, true},
+		{"1:00.000", 60, true},
+		{"12.000", 0, false},
+		{"00:aa.000", 0, false},
+		{"1:2:3:4", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseVTTTime(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseVTTTime(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseCueTiming(t *testing.T) {
+	cue, ok := parseCueTiming("00:01.000 --> 00:02.500 align:start position:10%")
+	if !ok || cue.start != 1 || cue.end != 2.5 || cue.settings != "align:start position:10%" {
+		t.Fatalf("parseCueTiming = %+v, %v", cue, ok)
+	}
+	for _, line := range []string{"00:02.000 --> 00:01.000", "00:01.000 -->", "garbage --> 00:01.000"} {
+		if _, ok := parseCueTiming(line); ok {
+			t.Errorf("parseCueTiming(%q) 应失败", line)
+		}
+	}
+}
+
+func TestParseVTT(t *testing.T) {
+	const input = "WEBVTT\r\n\r\n" +
+		"NOTE 这是注释 --> 不是字幕\r\n\r\n" +
+		"STYLE\r\n::cue { color: yellow }\r\n\r\n" +
+		"1\r\n00:00:01.000 --> 00:00:02.000\r\n第一行\r\n第二行\r\n\r\n" +
+		"00:00:05.000 --> 00:00:03.000\r\n倒序的时间被丢弃\r\n\r\n" +
+		"00:00:04.000 --> 00:00:06.500 line:0\r\n<i>second</i>\r\n"
+	path := filepath.Join(t.TempDir(), "full.vtt")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cues, err := parseVTT(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vttCue{
+		{start: 1, end: 2, text: "第一行\n第二行"},
+		{start: 4, end: 6.5, settings: "line:0", text: "<i>second</i>"},
+	}
+	if !reflect.DeepEqual(cues, want) {
+		t.Fatalf("parseVTT = %+v, want %+v", cues, want)
+	}
+}
+
+func TestWriteSegmentedVTT(t *testing.T) {
+	dir := t.TempDir()
+	cues := []vttCue{
+		{start: 1, end: 2, text: "a"},
+		{start: 3.5, end: 4.5, text: "跨分片"},
+		{start: 9, end: 9.5, settings: "align:end", text: "c"},
+	}
+	if err := writeSegmentedVTT(dir, cues, 9.8, 4, true); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		raw, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	playlist := read("index.m3u8")
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:4\n",
+		"#EXTINF:4.000,\nseg_00000.vtt\n",
+		"#EXTINF:4.000,\nseg_00001.vtt\n",
+		"#EXTINF:1.800,\nseg_00002.vtt\n",
+		"#EXT-X-ENDLIST\n",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Errorf("index.m3u8 缺少 %q:\n%s", want, playlist)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "seg_00003.vtt")); !os.IsNotExist(err) {
+		t.Error("不应生成超出时长的分片")
+	}
+
+	seg0, seg1, seg2 := read("seg_00000.vtt"), read("seg_00001.vtt"), read("seg_00002.vtt")
+	for _, seg := range []string{seg0, seg1, seg2} {
+		if !strings.HasPrefix(seg, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n") {
+			t.Errorf("分片缺少文件头或时间戳映射:\n%s", seg)
+		}
+	}
+	// 跨越 4 秒边界的字幕在前后两个分片中都出现
+	if !strings.Contains(seg0, "跨分片") || !strings.Contains(seg1, "跨分片") {
+		t.Errorf("跨分片字幕应同时出现在 seg 0 与 seg 1:\n%s\n%s", seg0, seg1)
+	}
+	if !strings.Contains(seg0, "\na\n") || strings.Contains(seg1, "\na\n") {
+		t.Errorf("字幕 a 只应出现在 seg 0")
+	}
+	if !strings.Contains(seg2, " align:end\nc\n") {
+		t.Errorf("seg 2 应保留 cue 设置:\n%s", seg2)
+	}
+}
+
+func TestWriteSegmentedVTTWithoutTimestampMap(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeSegmentedVTT(dir, nil, 0, 6, false); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "seg_00000.vtt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "WEBVTT\n" {
+		t.Fatalf("空字幕分片 = %q", raw)
+	}
+}
+
+func TestWithSubtitles(t *testing.T) {
+	master := []string{
+		"#EXTM3U",
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="old",LANGUAGE="fr",DEFAULT=NO,AUTOSELECT=YES,URI="subs/9/index.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=4128000,SUBTITLES="subs"`,
+		"1080p/index.m3u8",
+		"#EXT-X-STREAM-INF:BANDWIDTH=2928000",
+		"720p/index.m3u8",
+	}
+	subs := []store.MediaSubtitle{
+		{ID: 1, Language: "en", Label: "English"},
+		{ID: 2, Language: "en", Label: `Eng"lish`},
+		{ID: 3, Language: "en", Label: "English"},
+	}
+	got := withSubtitles(master, subs)
+	want := "#EXTM3U\n" +
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="` + SubtitleDir(1) + `/index.m3u8"` + "\n" +
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Eng'lish",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="` + SubtitleDir(2) + `/index.m3u8"` + "\n" +
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English (2)",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="` + SubtitleDir(3) + `/index.m3u8"` + "\n" +
+		`#EXT-X-STREAM-INF:BANDWIDTH=4128000,SUBTITLES="subs"` + "\n" +
+		"1080p/index.m3u8\n" +
+		`#EXT-X-STREAM-INF:BANDWIDTH=2928000,SUBTITLES="subs"` + "\n" +
+		"720p/index.m3u8\n"
+	if got != want {
+		t.Fatalf("withSubtitles =\n%s\nwant\n%s", got, want)
+	}
+
+	cleared := withSubtitles(strings.Split(strings.TrimSuffix(got, "\n"), "\n"), nil)
+	if strings.Contains(cleared, "SUBTITLES") {
+		t.Fatalf("subs 为空时应去掉全部字幕声明:\n%s", cleared)
+	}
+}
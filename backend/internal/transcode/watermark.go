@@ -0,0 +1,94 @@
+package transcode
+
+import (
+	"fmt"
+	"strconv"
+
+	"parallel/internal/profile"
+)
+
+// watermark 一次转码实际使用的水印：配置参数、占位符替换后写入的文字文件，以及源视频高度。
+// 水印在拆分码率阶梯之前按源分辨率叠加，随各档一起缩放，相对输出高度的比例在每一档都相同
+type watermark struct {
+	profile.Watermark
+	textFile string // drawtext 从文件读取文字，避免在滤镜参数中转义用户输入
+	height   int
+}
+
+func newWatermark(w *profile.Watermark, textFile string, src sourceVideo) *watermark {
+	if w == nil {
+		return nil
+	}
+	height := src.height
+	if height <= 0 {
+		height = 1080
+	}
+	return &watermark{Watermark: *w, textFile: textFile, height: height}
+}
+
+// apply 在 cmd 中追加水印图片输入与叠加滤镜，返回叠加后的视频标签；w 为 nil 时原样返回 in
+func (w *watermark) apply(cmd *Command, in string) string {
+	if w == nil {
+		return in
+	}
+	margin := w.pixels(w.Margin)
+	out := in
+	stack := 0 // 同时有图片与文字时，文字错开图片的高度，避免重叠
+	if w.Image != "" {
+		input := len(cmd.Inputs)
+		cmd.Inputs = append(cmd.Inputs, Input{Path: w.Image})
+		x, y := position(w.Position, margin, 0, "W", "H", "w", "h")
+		stack = w.pixels(w.Scale) + margin
+		cmd.Filters = append(cmd.Filters,
+			Filter{
+				Inputs:  []string{fmt.Sprintf("%d:v", input)},
+				Expr:    fmt.Sprintf("scale=-2:%d,format=rgba,colorchannelmixer=aa=%s", w.pixels(w.Scale), decimal(w.Opacity)),
+				Outputs: []string{"wm_image"},
+			},
+			Filter{Inputs: []string{out, "wm_image"}, Expr: fmt.Sprintf("overlay=x=%s:y=%s", x, y), Outputs: []string{"wm_overlay"}},
+		)
+		out = "wm_overlay"
+	}
+	if w.textFile != "" {
+		size := w.pixels(w.Scale)
+		x, y := position(w.Position, margin, stack, "w", "h", "tw", "th")
+		expr := fmt.Sprintf("drawtext=textfile='%s':expansion=none:fontsize=%d:fontcolor=white@%s:borderw=%d:bordercolor=black@%s:x=%s:y=%s",
+			w.textFile, size, decimal(w.Opacity), max(1, size/16), decimal(w.Opacity), x, y)
+		if w.Font != "" {
+			expr += fmt.Sprintf(":fontfile='%s'", w.Font)
+		}
+		cmd.Filters = append(cmd.Filters, Filter{Inputs: []string{out}, Expr: expr, Outputs: []string{"wm_text"}})
+		out = "wm_text"
+	}
+	return out
+}
+
+// pixels 把相对源高度的比例换算为像素，取偶数以满足 yuv420p 的尺寸要求
+func (w *watermark) pixels(ratio float64) int {
+	return max(2, int(ratio*float64(w.height)/2+0.5)*2)
+}
+
+// position 返回 overlay / drawtext 的 x、y 表达式，main 为画面宽高变量名，obj 为水印宽高变量名；
+// stack 为向画面内侧（居中时向下）额外错开的像素
+func position(pos string, margin, stack int, mainW, mainH, objW, objH string) (string, string) {
+	m := strconv.Itoa(margin)
+	left := m
+	right := fmt.Sprintf("%s-%s-%s", mainW, objW, m)
+	top := strconv.Itoa(margin + stack)
+	bottom := fmt.Sprintf("%s-%s-%d", mainH, objH, margin+stack)
+	switch pos {
+	case profile.PositionTopLeft:
+		return left, top
+	case profile.PositionTopRight:
+		return right, top
+	case profile.PositionBottomLeft:
+		return left, bottom
+	case profile.PositionCenter:
+		return fmt.Sprintf("(%s-%s)/2", mainW, objW), fmt.Sprintf("(%s-%s)/2+%d", mainH, objH, stack)
+	}
+	return right, bottom
+}
+
+func decimal(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,9 @@
+-- media_variants 改为保存存储 key，对外 URL 在读取时按 PUBLIC_BASE_URL 拼接
+-- 旧数据的 cdn_url 形如 /hls/media-<id>/index.m3u8，去掉 /hls/ 前缀即为 key
+-- cdn_url 列暂不删除，便于回滚
+
+ALTER TABLE `media_variants` ADD COLUMN `storage_key` varchar(512) DEFAULT NULL AFTER `format`;
+
+UPDATE `media_variants`
+SET `storage_key` = SUBSTRING(`cdn_url`, 6)
+WHERE `storage_key` IS NULL AND `cdn_url` LIKE '/hls/%';
@@ -0,0 +1,21 @@
+-- CDN 刷新请求记录
+
+CREATE TABLE IF NOT EXISTS `cdn_purge_attempts` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `media_id` bigint(20) unsigned DEFAULT NULL,
+  `reason` varchar(32) DEFAULT NULL,
+  `targets` text,
+  `attempt` bigint(20) DEFAULT NULL,
+  `success` tinyint(1) DEFAULT NULL,
+  `error` varchar(1024) DEFAULT NULL,
+  `created_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_cdn_purge_attempts_media_id` (`media_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 记录源文件存储 key，删除资源时据此清理上传存储
+ALTER TABLE `media_assets` ADD COLUMN `source_key` varchar(512) DEFAULT NULL AFTER `original_url`;
+
+UPDATE `media_assets`
+SET `source_key` = `original_url`
+WHERE `source_key` IS NULL AND `original_url` NOT LIKE 'http%';
@@ -0,0 +1,9 @@
+-- 每个 OwnerID 的存储与转码用量
+
+CREATE TABLE IF NOT EXISTS `owner_usages` (
+  `owner_id` varchar(64) NOT NULL,
+  `bytes_stored` bigint(20) DEFAULT NULL,
+  `transcode_seconds` double DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`owner_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+-- 作业 outbox：与 media_assets 同事务写入，relay 发布到 Redis Stream 后标记 SENT
+
+CREATE TABLE IF NOT EXISTS `job_outboxes` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `media_id` bigint(20) unsigned DEFAULT NULL,
+  `payload` text,
+  `status` varchar(16) DEFAULT NULL,
+  `attempts` bigint(20) DEFAULT NULL,
+  `last_error` varchar(1024) DEFAULT NULL,
+  `created_at` datetime(3) DEFAULT NULL,
+  `sent_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_job_outboxes_media_id` (`media_id`),
+  KEY `idx_job_outboxes_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,23 @@
+-- 作业心跳与卡住作业巡检
+
+ALTER TABLE `transcode_jobs`
+  ADD COLUMN `message_id` varchar(64) DEFAULT NULL,
+  ADD COLUMN `consumer` varchar(128) DEFAULT NULL,
+  ADD COLUMN `heartbeat_at` datetime(3) DEFAULT NULL,
+  ADD COLUMN `error` varchar(1024) DEFAULT NULL;
+
+ALTER TABLE `media_assets` ADD COLUMN `fail_reason` varchar(512) DEFAULT NULL AFTER `source_key`;
+
+ALTER TABLE `job_outboxes` ADD COLUMN `message_id` varchar(64) DEFAULT NULL AFTER `status`;
+
+CREATE TABLE IF NOT EXISTS `reconcile_decisions` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `media_id` bigint(20) unsigned DEFAULT NULL,
+  `job_id` bigint(20) unsigned DEFAULT NULL,
+  `message_id` varchar(64) DEFAULT NULL,
+  `decision` varchar(32) DEFAULT NULL,
+  `reason` varchar(512) DEFAULT NULL,
+  `created_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_reconcile_decisions_media_id` (`media_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+-- 作业幂等：media_variants 按 (media_id, quality, format) 唯一，transcode_jobs 记录幂等 key
+
+-- 先清理重复投递产生的重复 variant，仅保留每组最新一条
+DELETE v1 FROM `media_variants` v1
+JOIN `media_variants` v2
+  ON v1.media_id = v2.media_id AND v1.quality = v2.quality AND v1.format = v2.format AND v1.id < v2.id;
+
+ALTER TABLE `media_variants`
+  ADD UNIQUE KEY `idx_media_variants_identity` (`media_id`, `quality`, `format`);
+
+ALTER TABLE `transcode_jobs`
+  ADD COLUMN `idempotency_key` varchar(64) DEFAULT NULL AFTER `message_id`,
+  ADD KEY `idx_transcode_jobs_idempotency_key` (`idempotency_key`);
@@ -0,0 +1,4 @@
+-- 优先级队列：资源记录作业所在的优先级与探测到的源文件时长（duration 列已存在）
+
+ALTER TABLE `media_assets`
+  ADD COLUMN `priority` varchar(16) DEFAULT NULL AFTER `duration`;
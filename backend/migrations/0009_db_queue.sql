@@ -0,0 +1,8 @@
+-- 数据库队列（QUEUE_BACKEND=db）：待执行的作业以 QUEUED / LEASED 状态写入 transcode_jobs
+
+ALTER TABLE `transcode_jobs`
+  ADD COLUMN `priority` varchar(16) DEFAULT NULL,
+  ADD COLUMN `available_at` datetime(3) DEFAULT NULL,
+  ADD COLUMN `leased_at` datetime(3) DEFAULT NULL,
+  ADD COLUMN `payload` text,
+  ADD KEY `idx_transcode_jobs_queue` (`state`, `priority`, `available_at`);
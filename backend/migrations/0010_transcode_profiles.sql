@@ -0,0 +1,7 @@
+-- 转码配置：资源记录提交时选定的配置，variant 记录产出它的配置
+
+ALTER TABLE `media_assets`
+  ADD COLUMN `profile` varchar(32) DEFAULT NULL AFTER `priority`;
+
+ALTER TABLE `media_variants`
+  ADD COLUMN `profile` varchar(32) DEFAULT NULL AFTER `storage_key`;
@@ -0,0 +1,6 @@
+-- 多编码输出：variant 记录 CODECS，同一档位可同时有 h264 / hevc / av1 三种编码，唯一键加入 codec
+
+ALTER TABLE `media_variants`
+  ADD COLUMN `codec` varchar(64) NOT NULL DEFAULT '' AFTER `format`,
+  DROP INDEX `idx_media_variants_identity`,
+  ADD UNIQUE KEY `idx_media_variants_identity` (`media_id`, `quality`, `format`, `codec`);
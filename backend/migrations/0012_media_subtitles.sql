@@ -0,0 +1,17 @@
+-- 字幕轨：上传或从源文件提取的字幕，打包为分段 WebVTT 后写入主播放列表的 SUBTITLES 组
+
+CREATE TABLE IF NOT EXISTS `media_subtitles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `media_id` bigint unsigned DEFAULT NULL,
+  `language` varchar(16) DEFAULT NULL,
+  `label` varchar(64) DEFAULT NULL,
+  `origin` varchar(16) DEFAULT NULL,
+  `stream_index` bigint DEFAULT NULL,
+  `source_key` varchar(512) DEFAULT NULL,
+  `storage_key` varchar(512) DEFAULT NULL,
+  `created_at` datetime(3) DEFAULT NULL,
+  `updated_at` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_media_subtitles_media_id` (`media_id`),
+  UNIQUE KEY `idx_media_subtitles_stream` (`media_id`, `stream_index`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
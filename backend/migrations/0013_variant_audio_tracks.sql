@@ -0,0 +1,5 @@
+-- 备选音轨：每条音轨记录为一个 AUDIO variant，附带源文件中的语言与名称
+
+ALTER TABLE `media_variants`
+  ADD COLUMN `language` varchar(16) DEFAULT NULL,
+  ADD COLUMN `label` varchar(64) DEFAULT NULL;
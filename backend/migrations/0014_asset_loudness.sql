@@ -0,0 +1,4 @@
+-- 响度标准化：记录转码时测得的默认音轨积分响度（LUFS），未测量时为 NULL
+
+ALTER TABLE `media_assets`
+  ADD COLUMN `loudness` double DEFAULT NULL AFTER `profile`;
@@ -0,0 +1,4 @@
+-- 水印：记录上传时按资源覆盖的水印参数，对账重新投递作业时沿用
+
+ALTER TABLE `media_assets`
+  ADD COLUMN `watermark` text DEFAULT NULL AFTER `loudness`;
@@ -0,0 +1,7 @@
+-- 0003 迁移把旧版本 original_url 中 UPLOAD_DIR 下的文件路径整体写入了 source_key；
+-- 上传源文件的存储 key 都是不含目录的文件名。
+-- 远程拉取的源文件未入库，由清理任务按 remote-<id>-*.mp4 的命名补录
+
+UPDATE `media_assets`
+SET `source_key` = SUBSTRING_INDEX(`source_key`, '/', -1)
+WHERE `source_key` LIKE '%/%';
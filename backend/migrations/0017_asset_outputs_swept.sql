@@ -0,0 +1,4 @@
+-- 记录失败资源残留产物的清理时间，清理任务据此跳过已处理的资源；再次失败时置空
+
+ALTER TABLE `media_assets`
+  ADD COLUMN `outputs_swept_at` datetime(3) DEFAULT NULL AFTER `updated_at`;
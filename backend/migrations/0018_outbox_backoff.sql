@@ -0,0 +1,4 @@
+-- outbox 单条发布失败后的退避时间，失败记录不再阻塞同批其他记录
+
+ALTER TABLE `job_outboxes`
+  ADD COLUMN `next_attempt_at` datetime(3) DEFAULT NULL AFTER `sent_at`;
@@ -54,3 +54,36 @@ func UserClaims(c *gin.Context) any {
 	claims, _ := c.Get(string(userClaimsKey))
 	return claims
 }
+
+// DevOwnerID 开发环境跳过认证时使用的默认用户
+const DevOwnerID = "demo-user"
+
+// OwnerID 返回 token 中的 sub，未认证（开发环境）时返回 DevOwnerID
+func OwnerID(c *gin.Context) string {
+	if claims, ok := UserClaims(c).(jwt.MapClaims); ok {
+		if sub, err := claims.GetSubject(); err == nil && sub != "" {
+			return sub
+		}
+	}
+	return DevOwnerID
+}
+
+// Claim 返回 token 中的字符串 claim，未认证或不存在时返回空串
+func Claim(c *gin.Context, name string) string {
+	if claims, ok := UserClaims(c).(jwt.MapClaims); ok {
+		if v, ok := claims[name].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Plan 返回 token 中的套餐（plan claim）
+func Plan(c *gin.Context) string {
+	return Claim(c, "plan")
+}
+
+// Role 返回 token 中的角色（role claim）
+func Role(c *gin.Context) string {
+	return Claim(c, "role")
+}
@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -11,11 +13,99 @@ type Config struct {
 	HTTPAddr           string
 	DatabaseDSN        string
 	RedisURL           string
+	QueueBackend       string // redis / memory / db，memory 仅适用于单实例部署
 	QueueStream        string
 	JWTSecret          string
 	FFmpegBinary       string
 	TranscodeOutputDir string
 	UploadDir          string
+	// WorkDir 存放转码过程中的临时文件（远端源文件副本、未上传的输出）
+	WorkDir string
+
+	// StorageBackend 取值 local / s3
+	StorageBackend string
+	S3Endpoint     string
+	S3Region       string
+	S3Bucket       string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3UseSSL       bool
+	S3SourcePrefix string
+	S3OutputPrefix string
+	// PublicBaseURL 播放资源对外访问前缀，读取时与 variant 的存储 key 拼接；
+	// 切换 CDN 只需修改此项，无需改写数据库
+	PublicBaseURL string
+	// PlaylistMaxAge / SegmentMaxAge 控制 /hls 返回的 Cache-Control
+	PlaylistMaxAge time.Duration
+	SegmentMaxAge  time.Duration
+
+	// PurgeWebhookURL 为空时不做 CDN 刷新
+	PurgeWebhookURL   string
+	PurgeWebhookToken string
+	PurgeMaxAttempts  int
+
+	// JanitorInterval 为 0 时不启动清理任务
+	JanitorInterval       time.Duration
+	JanitorDryRun         bool
+	SourceRetention       time.Duration
+	FailedOutputRetention time.Duration
+	OrphanGracePeriod     time.Duration
+	JanitorOrphanSources  bool
+
+	// 每个 OwnerID 的配额，0 表示不限制
+	QuotaMaxBytes            int64
+	QuotaMaxTranscodeSeconds float64
+
+	// OutboxPollInterval relay 兜底轮询间隔；OutboxRetention 已发送记录保留时长
+	OutboxPollInterval time.Duration
+	OutboxRetention    time.Duration
+
+	// 卡住作业巡检
+	ReconcileInterval    time.Duration
+	JobStaleAfter        time.Duration
+	RemoteFetchTimeout   time.Duration
+	ReconcileMaxRequeues int
+	JobMaxDeliveries     int
+
+	// QueueConsumer 本实例的消费者名，默认取主机名；JobLeaseTTL 作业租约时长
+	QueueConsumer string
+	JobLeaseTTL   time.Duration
+
+	// 优先级队列：QueueWeights 形如 "high=6,normal=3,bulk=1"，PriorityPlans 形如 "pro=high,free=bulk"
+	FFprobeBinary         string
+	QueueWeights          string
+	PriorityPlans         string
+	PriorityShortSource   time.Duration
+	PriorityLongSource    time.Duration
+	PriorityElevatedRoles string
+
+	// 公平调度：本实例并发数、单个 owner 并发上限（0 不限）与预取缓冲大小（0 取并发数的 8 倍）
+	TranscodeConcurrency int
+	OwnerMaxConcurrency  int
+	QueuePrefetch        int
+
+	// QueueDBPollInterval QUEUE_BACKEND=db 时空闲轮询间隔
+	QueueDBPollInterval time.Duration
+
+	// Redis Stream 裁剪：MaxLen 为 XADD 的长度上限（0 不限），Retention 为已确认消息的保留时长
+	QueueStreamMaxLen    int64
+	QueueStreamRetention time.Duration
+	QueueTrimInterval    time.Duration
+
+	// TranscodeProfiles 转码配置 JSON 文件路径，为空时只有内置的 default 配置
+	TranscodeProfiles       string
+	TranscodeDefaultProfile string
+
+	// 封面与缩略图：ArtworkEnabled 为 false 时不生成；ThumbnailInterval 为 0 时只生成封面
+	ArtworkEnabled    bool
+	ThumbnailInterval time.Duration
+	ThumbnailWidth    int
+	SpriteColumns     int
+	SpriteRows        int
+	// PreviewDuration 悬停预览总时长，0 不生成；由 PreviewClips 段场景变化最明显的片段拼接
+	PreviewDuration time.Duration
+	PreviewClips    int
+	PreviewFormat   string
 }
 
 func Load() Config {
@@ -24,17 +114,85 @@ func Load() Config {
 		HTTPAddr:           getenv("HTTP_ADDR", ":8080"),
 		DatabaseDSN:        getenv("DATABASE_DSN", "root:123456@tcp(10.2.128.120:3306)/parallel?parseTime=true"),
 		RedisURL:           getenv("REDIS_URL", "redis://localhost:6379/0"),
+		QueueBackend:       getenv("QUEUE_BACKEND", "redis"),
 		QueueStream:        getenv("QUEUE_STREAM", "transcode_jobs"),
 		JWTSecret:          getenv("JWT_SECRET", "dev-secret"),
 		FFmpegBinary:       getenv("FFMPEG_BINARY", "ffmpeg"),
 		TranscodeOutputDir: getenv("TRANSCODE_OUTPUT", "./data/output"),
 		UploadDir:          getenv("UPLOAD_DIR", "./data/uploads"),
+		WorkDir:            getenv("WORK_DIR", "./data/work"),
+		StorageBackend:     getenv("STORAGE_BACKEND", "local"),
+		S3Endpoint:         getenv("S3_ENDPOINT", "localhost:9000"),
+		S3Region:           getenv("S3_REGION", "us-east-1"),
+		S3Bucket:           getenv("S3_BUCKET", "parallel"),
+		S3AccessKey:        getenv("S3_ACCESS_KEY", ""),
+		S3SecretKey:        getenv("S3_SECRET_KEY", ""),
+		S3UseSSL:           getenvBool("S3_USE_SSL", false),
+		S3SourcePrefix:     getenv("S3_SOURCE_PREFIX", "uploads/"),
+		S3OutputPrefix:     getenv("S3_OUTPUT_PREFIX", "hls/"),
+		PublicBaseURL:      getenv("PUBLIC_BASE_URL", "/hls"),
+		PlaylistMaxAge:     getenvDuration("CACHE_PLAYLIST_MAX_AGE", 30*time.Second),
+		SegmentMaxAge:      getenvDuration("CACHE_SEGMENT_MAX_AGE", time.Hour),
+		PurgeWebhookURL:    getenv("CDN_PURGE_WEBHOOK_URL", ""),
+		PurgeWebhookToken:  getenv("CDN_PURGE_WEBHOOK_TOKEN", ""),
+		PurgeMaxAttempts:   getenvInt("CDN_PURGE_MAX_ATTEMPTS", 5),
+		JanitorInterval:    getenvDuration("JANITOR_INTERVAL", time.Hour),
+		JanitorDryRun:      getenvBool("JANITOR_DRY_RUN", false),
+		// 默认永久保留源文件，便于重新转码
+		SourceRetention:          getenvDuration("RETENTION_SOURCE_AFTER_READY", 0),
+		FailedOutputRetention:    getenvDuration("RETENTION_FAILED_OUTPUT", 24*time.Hour),
+		OrphanGracePeriod:        getenvDuration("ORPHAN_GRACE_PERIOD", 24*time.Hour),
+		JanitorOrphanSources:     getenvBool("JANITOR_SWEEP_ORPHAN_SOURCES", false),
+		QuotaMaxBytes:            int64(getenvInt("QUOTA_MAX_STORAGE_BYTES", 0)),
+		QuotaMaxTranscodeSeconds: float64(getenvInt("QUOTA_MAX_TRANSCODE_SECONDS", 0)),
+		OutboxPollInterval:       getenvDuration("OUTBOX_POLL_INTERVAL", 2*time.Second),
+		OutboxRetention:          getenvDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+		ReconcileInterval:        getenvDuration("RECONCILE_INTERVAL", time.Minute),
+		JobStaleAfter:            getenvDuration("JOB_STALE_AFTER", 5*time.Minute),
+		RemoteFetchTimeout:       getenvDuration("REMOTE_FETCH_TIMEOUT", 2*time.Hour),
+		ReconcileMaxRequeues:     getenvInt("RECONCILE_MAX_REQUEUES", 3),
+		JobMaxDeliveries:         getenvInt("JOB_MAX_DELIVERIES", 5),
+		QueueConsumer:            getenv("QUEUE_CONSUMER", ""),
+		JobLeaseTTL:              getenvDuration("JOB_LEASE_TTL", 2*time.Minute),
+		FFprobeBinary:            getenv("FFPROBE_BINARY", "ffprobe"),
+		QueueWeights:             getenv("QUEUE_PRIORITY_WEIGHTS", "high=6,normal=3,bulk=1"),
+		PriorityPlans:            getenv("PRIORITY_PLANS", ""),
+		PriorityShortSource:      getenvDuration("PRIORITY_SHORT_SOURCE", 2*time.Minute),
+		PriorityLongSource:       getenvDuration("PRIORITY_LONG_SOURCE", 30*time.Minute),
+		PriorityElevatedRoles:    getenv("PRIORITY_ELEVATED_ROLES", "admin"),
+		TranscodeConcurrency:     getenvInt("TRANSCODE_CONCURRENCY", 1),
+		OwnerMaxConcurrency:      getenvInt("OWNER_MAX_CONCURRENCY", 0),
+		QueuePrefetch:            getenvInt("QUEUE_PREFETCH", 0),
+		QueueDBPollInterval:      getenvDuration("QUEUE_DB_POLL_INTERVAL", time.Second),
+		QueueStreamMaxLen:        int64(getenvInt("QUEUE_STREAM_MAXLEN", 0)),
+		QueueStreamRetention:     getenvDuration("QUEUE_STREAM_RETENTION", 24*time.Hour),
+		QueueTrimInterval:        getenvDuration("QUEUE_TRIM_INTERVAL", 5*time.Minute),
+		TranscodeProfiles:        getenv("TRANSCODE_PROFILES", ""),
+		TranscodeDefaultProfile:  getenv("TRANSCODE_DEFAULT_PROFILE", "default"),
+		ArtworkEnabled:           getenvBool("ARTWORK_ENABLED", true),
+		ThumbnailInterval:        getenvDuration("THUMBNAIL_INTERVAL", 10*time.Second),
+		ThumbnailWidth:           getenvInt("THUMBNAIL_WIDTH", 160),
+		SpriteColumns:            getenvInt("SPRITE_COLUMNS", 10),
+		SpriteRows:               getenvInt("SPRITE_ROWS", 10),
+		PreviewDuration:          getenvDuration("PREVIEW_DURATION", 6*time.Second),
+		PreviewClips:             getenvInt("PREVIEW_CLIPS", 3),
+		PreviewFormat:            getenv("PREVIEW_FORMAT", "mp4"),
 	}
 	if cfg.JWTSecret == "" {
 		log.Fatal("JWT_SECRET 未配置")
 	}
+	if cfg.QueueBackend != "redis" && cfg.QueueBackend != "memory" && cfg.QueueBackend != "db" {
+		log.Fatalf("QUEUE_BACKEND 非法: %s", cfg.QueueBackend)
+	}
+	if cfg.PreviewFormat != "mp4" && cfg.PreviewFormat != "webp" {
+		log.Fatalf("PREVIEW_FORMAT 非法: %s", cfg.PreviewFormat)
+	}
+	if cfg.StorageBackend != "local" && cfg.StorageBackend != "s3" {
+		log.Fatalf("STORAGE_BACKEND 非法: %s", cfg.StorageBackend)
+	}
 	mustEnsureDir(cfg.TranscodeOutputDir)
 	mustEnsureDir(cfg.UploadDir)
+	mustEnsureDir(cfg.WorkDir)
 	return cfg
 }
 
@@ -53,3 +211,39 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+func getenvBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%s 取值非法: %s", key, v)
+	}
+	return b
+}
+
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s 取值非法: %s", key, v)
+	}
+	return n
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s 取值非法: %s", key, v)
+	}
+	return d
+}
@@ -0,0 +1,26 @@
+package textutil
+
+import "unicode/utf8"
+
+// Truncate 按字节截断且不拆分 UTF-8 字符，半个字符会被 utf8mb4 列拒绝
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Tail 取末尾至多 n 字节，同样不拆分 UTF-8 字符
+func Tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
@@ -11,6 +11,8 @@ type PlaybackVariant = {
 type PlaybackResponse = {
   status: string;
   variants: PlaybackVariant[];
+  poster?: string;
+  trickplay?: string;
 };
 
 export default function App() {
@@ -169,7 +171,7 @@ export default function App() {
       </section>
       <main className={styles.playerArea}>
         {playback && variantUrl ? (
-          <DualVideoPlayer leftSrc={variantUrl} rightSrc={variantUrl} />
+          <DualVideoPlayer leftSrc={variantUrl} rightSrc={variantUrl} poster={playback?.poster} />
         ) : (
           <div className={styles.placeholder}>
             {polling || playback?.status === "PROCESSING"